@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noamstrauss/ota-updater/config"
+	"github.com/noamstrauss/ota-updater/updater"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// progressBarWidth is the number of cells of the download progress bar
+const progressBarWidth = 30
+
+// runCheckNow performs a single update check for the application and returns the exit code.
+// On a terminal it shows a progress bar and concise colored status lines instead of log output.
+func runCheckNow(cfg *config.Config) int {
+	updateConfig := newUpdaterConfig(cfg, updateTargets(cfg)[0])
+	updateConfig.ForceReinstall = *reinstall
+	// An update requested by hand is not held back by the budget, cooldown, backoff or grace period meant for unattended ones
+	updateConfig.MaxUpdatesPerWindow = 0
+	updateConfig.RestartCooldown = 0
+	updateConfig.FailureBackoff = 0
+	updateConfig.ApplyGracePeriod = 0
+
+	interactive := isTerminal(os.Stdout)
+	var bar *progressBar
+	if interactive {
+		bar = &progressBar{w: os.Stdout, last: -1}
+		updateConfig.Progress = bar.update
+		updateConfig.Logger = log.New(os.Stdout, "", 0)
+	}
+
+	result, err := updater.CheckAndUpdate(updateConfig)
+	if bar != nil {
+		bar.finish()
+	}
+
+	switch {
+	case err != nil:
+		status(interactive, colorRed, "Update failed: %v", err)
+		return 1
+	case result.Deferred && !result.DownloadAt.IsZero():
+		status(interactive, colorYellow, "Update %s available, it will be downloaded after %s", result.Version, result.DownloadAt.Format("2006-01-02 15:04 MST"))
+	case result.Deferred && result.ApplyAt.IsZero():
+		status(interactive, colorYellow, "Update %s deferred until the battery is charged", result.Version)
+	case result.Deferred:
+		status(interactive, colorYellow, "Update %s downloaded, it will be applied after %s", result.Version, result.ApplyAt.Format("2006-01-02 15:04 MST"))
+	case result.Updated:
+		status(interactive, colorGreen, "Updated to version %s", result.Version)
+	default:
+		status(interactive, colorGreen, "Already up to date (%s)", result.Version)
+	}
+	return 0
+}
+
+// runVerifyRelease verifies the latest release of the application without installing it,
+// prints a line per step and returns the exit code
+func runVerifyRelease(cfg *config.Config) int {
+	interactive := isTerminal(os.Stdout)
+	report, err := updater.VerifyRelease(newUpdaterConfig(cfg, updateTargets(cfg)[0]))
+	if err != nil {
+		status(interactive, colorRed, "Verification failed: %v", err)
+		return 1
+	}
+
+	for _, step := range report.Steps {
+		switch {
+		case step.Skipped:
+			status(interactive, colorYellow, "SKIP %-16s %s", step.Name, step.Detail)
+		case step.Passed:
+			status(interactive, colorGreen, "PASS %-16s %s (%s)", step.Name, step.Detail, step.Duration.Round(time.Millisecond))
+		default:
+			status(interactive, colorRed, "FAIL %-16s %s", step.Name, step.Detail)
+		}
+	}
+
+	switch {
+	case report.Version == "":
+		status(interactive, colorRed, "No release to verify")
+		return 1
+	case !report.Passed():
+		status(interactive, colorRed, "Release %s failed verification", report.Version)
+		return 1
+	}
+	status(interactive, colorGreen, "Release %s passed verification", report.Version)
+	return 0
+}
+
+// status prints a colored line on a terminal and a plain log line otherwise
+func status(interactive bool, color, format string, args ...interface{}) {
+	if !interactive {
+		log.Printf(format, args...)
+		return
+	}
+	fmt.Printf("%s%s%s\n", color, fmt.Sprintf(format, args...), colorReset)
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders download progress on a single terminal line
+type progressBar struct {
+	w     io.Writer
+	last  int64
+	drawn bool
+}
+
+// update redraws the bar whenever the shown value changes
+func (p *progressBar) update(done, total int64) {
+	if total <= 0 {
+		// Without a known size only redraw for every additional MiB
+		if mib := done >> 20; mib != p.last {
+			p.last = mib
+			fmt.Fprintf(p.w, "\rDownloading... %s", formatBytes(done))
+			p.drawn = true
+		}
+		return
+	}
+
+	percent := done * 100 / total
+	if percent == p.last {
+		return
+	}
+	p.last = percent
+
+	filled := int(percent) * progressBarWidth / 100
+	fmt.Fprintf(p.w, "\r[%s%s] %3d%% %s/%s",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		percent, formatBytes(done), formatBytes(total))
+	p.drawn = true
+}
+
+// finish ends the progress line so following output starts on a new line
+func (p *progressBar) finish() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// formatBytes formats a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,67 @@
+// config/channel.go
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Release channels a device can follow
+const (
+	// ChannelStable never installs pre-releases
+	ChannelStable = "stable"
+	// ChannelBeta installs pre-releases as well as releases
+	ChannelBeta = "beta"
+)
+
+// ErrSignedConfig is returned when a setting of a signed config file is changed on the device
+var ErrSignedConfig = errors.New("signed config file cannot be changed")
+
+// ValidChannel reports whether channel is a known channel, or empty to follow include_prerelease
+func ValidChannel(channel string) bool {
+	return channel == "" || channel == ChannelStable || channel == ChannelBeta
+}
+
+// IncludesPrereleases returns whether pre-releases are installed. A channel decides when
+// one is set, include_prerelease otherwise.
+func (c *Config) IncludesPrereleases() *bool {
+	if c.Channel == "" {
+		return c.IncludePrerelease
+	}
+	beta := c.Channel == ChannelBeta
+	return &beta
+}
+
+// SetChannel records the release channel in the config file. The file is re-read so values
+// coming from environment overrides are not persisted.
+func SetChannel(configPath, channel string) error {
+	if !ValidChannel(channel) {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+
+	targetMu.Lock()
+	defer targetMu.Unlock()
+
+	key, err := trustedKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		return fmt.Errorf("%w: the channel is set by whoever signs it", ErrSignedConfig)
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(file, config); err != nil {
+		return fmt.Errorf("failed to parse config JSON: %w", err)
+	}
+
+	config.Channel = channel
+	return config.SaveConfig(configPath)
+}
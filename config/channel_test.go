@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestIncludesPrereleases(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		channel string
+		include *bool
+		want    *bool
+	}{
+		{name: "no channel, unset", want: nil},
+		{name: "no channel, included", include: &yes, want: &yes},
+		{name: "no channel, excluded", include: &no, want: &no},
+		{name: "stable excludes", channel: ChannelStable, want: &no},
+		{name: "stable overrides included", channel: ChannelStable, include: &yes, want: &no},
+		{name: "beta includes", channel: ChannelBeta, want: &yes},
+		{name: "beta overrides excluded", channel: ChannelBeta, include: &no, want: &yes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Channel: tt.channel, IncludePrerelease: tt.include}
+			got := c.IncludesPrereleases()
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("IncludesPrereleases() = %v, want %v", tristate(got), tristate(tt.want))
+			}
+		})
+	}
+}
+
+// tristate prints a tri-state setting
+func tristate(b *bool) string {
+	if b == nil {
+		return "unset"
+	}
+	if *b {
+		return "true"
+	}
+	return "false"
+}
@@ -4,33 +4,155 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
+var (
+	// FileMode is used when saving a config file without secrets
+	FileMode os.FileMode = 0644
+	// SecretFileMode is used when saving a config file that contains a GitHub token
+	SecretFileMode os.FileMode = 0600
+)
+
+// targetMu serializes config file rewrites from concurrent target updaters
+var targetMu sync.Mutex
+
 type Config struct {
-	UpdateInterval time.Duration `json:"update_interval"`
+	UpdateInterval       time.Duration `json:"update_interval"`
+	GithubRepo           string        `json:"github_repo"`
+	GithubToken          string        `json:"github_token,omitempty"`
+	FallbackRepos        []string      `json:"fallback_repos,omitempty"`
+	LogLevel             string        `json:"log_level"`
+	Targets              []Target      `json:"targets,omitempty"`
+	DownloadRateLimit    int64         `json:"download_rate_limit,omitempty"`
+	MinDownloadRate      int64         `json:"min_download_rate,omitempty"`
+	ParallelHashSize     int64         `json:"parallel_hash_size,omitempty"`
+	StallPeriod          time.Duration `json:"stall_period,omitempty"`
+	BackupCount          int           `json:"backup_count"`
+	CreateBackup         bool          `json:"create_backup"`
+	SymlinkStrategy      string        `json:"symlink_strategy,omitempty"`
+	CrashLimit           int           `json:"crash_limit"`
+	CrashWindow          time.Duration `json:"crash_window"`
+	ShutdownTimeout      time.Duration `json:"shutdown_timeout"`
+	PinnedVersion        string        `json:"pinned_version,omitempty"`
+	VersionProbe         []string      `json:"version_probe,omitempty"`
+	RepairCorrupted      bool          `json:"repair_corrupted,omitempty"`
+	AllowDowngrade       bool          `json:"allow_downgrade,omitempty"`
+	SkipVersions         []string      `json:"skip_versions,omitempty"`
+	IncludePrerelease    *bool         `json:"include_prerelease,omitempty"`
+	Channel              string        `json:"channel,omitempty"`
+	ReleaseScanDepth     int           `json:"release_scan_depth,omitempty"`
+	SlowUpdateWarning    time.Duration `json:"slow_update_warning,omitempty"`
+	ProbeAddress         string        `json:"probe_address,omitempty"`
+	ProbeTimeout         time.Duration `json:"probe_timeout,omitempty"`
+	OfflineRetryInterval time.Duration `json:"offline_retry_interval,omitempty"`
+	CriticalInterval     time.Duration `json:"critical_interval,omitempty"`
+	MaxUpdatesPerWindow  int           `json:"max_updates_per_window,omitempty"`
+	UpdateBudgetWindow   time.Duration `json:"update_budget_window,omitempty"`
+	RestartCooldown      time.Duration `json:"restart_cooldown,omitempty"`
+	ApplyGracePeriod     time.Duration `json:"apply_grace_period,omitempty"`
+	MaxApplyDeferral     time.Duration `json:"max_apply_deferral,omitempty"`
+	FailureBackoff       time.Duration `json:"failure_backoff,omitempty"`
+	AuditLog             string        `json:"audit_log,omitempty"`
+	MaintenanceWindow    *Window       `json:"maintenance_window,omitempty"`
+	DownloadWindow       *Window       `json:"download_window,omitempty"`
+	ClockSkewTolerance   time.Duration `json:"clock_skew_tolerance,omitempty"`
+	MinBatteryPercent    int           `json:"min_battery_percent,omitempty"`
+	UseServerTime        bool          `json:"use_server_time,omitempty"`
+	StagingDir           string        `json:"staging_dir,omitempty"`
+	ChecksumAlgorithm    string        `json:"checksum_algorithm,omitempty"`
+	ChecksumSources      []string      `json:"checksum_sources,omitempty"`
+	AssetPreference      string        `json:"asset_preference,omitempty"`
+	AssetVersionPattern  string        `json:"asset_version_pattern,omitempty"`
+	OnMissingAsset       string        `json:"on_missing_asset,omitempty"`
+	AllowSetuid          bool          `json:"allow_setuid,omitempty"`
+	AllowInstallScripts  bool          `json:"allow_install_scripts,omitempty"`
+	InstallScriptTimeout time.Duration `json:"install_script_timeout,omitempty"`
+	VersionProbeTimeout  time.Duration `json:"version_probe_timeout,omitempty"`
+	ManifestPublicKey    string        `json:"manifest_public_key,omitempty"`
+	CertificatePins      []string      `json:"certificate_pins,omitempty"`
+	Cosign               *Cosign       `json:"cosign,omitempty"`
+	TUF                  *TUF          `json:"tuf,omitempty"`
+	DirectorySource      string        `json:"directory_source,omitempty"`
+	ControlAddress       string        `json:"control_address,omitempty"`
+	ControlToken         string        `json:"control_token,omitempty"`
+	DeviceID             string        `json:"device_id,omitempty"`
+	RegistrationURL      string        `json:"registration_url,omitempty"`
+	RegistrationToken    string        `json:"registration_token,omitempty"`
+}
+
+// TUF locates a repository of The Update Framework and the trusted root to bootstrap it
+type TUF struct {
+	RepositoryURL string `json:"repository_url"`
+	RootPath      string `json:"root_path"`
+	MetadataDir   string `json:"metadata_dir,omitempty"`
+}
+
+// Cosign names who must have signed release assets through Sigstore
+type Cosign struct {
+	Identity        string `json:"identity,omitempty"`
+	IdentityRegexp  string `json:"identity_regexp,omitempty"`
+	Issuer          string `json:"issuer"`
+	TrustedRootPath string `json:"trusted_root,omitempty"`
+}
+
+// Window is a daily time range given as "HH:MM" in an optional IANA timezone
+type Window struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Target describes an additional artifact kept up to date by the updater
+type Target struct {
+	Name           string        `json:"name"`
 	GithubRepo     string        `json:"github_repo"`
-	GithubToken    string        `json:"github_token,omitempty"`
-	LogLevel       string        `json:"log_level"`
+	FallbackRepos  []string      `json:"fallback_repos,omitempty"`
+	ExecutablePath string        `json:"executable_path"`
+	CurrentVersion string        `json:"current_version"`
+	PinnedVersion  string        `json:"pinned_version,omitempty"`
+	VersionProbe   []string      `json:"version_probe,omitempty"`
+	VersionSource  string        `json:"version_source,omitempty"`
+	VersionFile    string        `json:"version_file,omitempty"`
+	UpdateInterval time.Duration `json:"update_interval,omitempty"`
+	SourceArchive  string        `json:"source_archive,omitempty"`
+	TargetDir      string        `json:"target_dir,omitempty"`
+	ReadyMarker    bool          `json:"ready_marker,omitempty"`
 }
 
 // DefaultConfig returns a Config struct with default values
 func DefaultConfig() *Config {
 	return &Config{
-		UpdateInterval: 1 * time.Minute,
-		GithubRepo:     "noamstrauss/ota-updater",
-		LogLevel:       "info",
+		UpdateInterval:  1 * time.Minute,
+		GithubRepo:      "noamstrauss/ota-updater",
+		LogLevel:        "info",
+		BackupCount:     1,
+		CreateBackup:    true,
+		CrashWindow:     10 * time.Minute,
+		ShutdownTimeout: 10 * time.Second,
 	}
 }
 
-// LoadConfig loads the config from the specified file or creates a default one if missing
+// LoadConfig loads the config from the specified file or creates a default one if missing.
+// When built with a TrustedKey, the file must be signed by it and is never created.
 func LoadConfig(configPath string) (*Config, error) {
 	config := DefaultConfig()
 
+	key, err := trustedKey()
+	if err != nil {
+		return nil, err
+	}
+
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if key != nil {
+			return nil, fmt.Errorf("%w: %s does not exist", ErrUntrustedConfig, configPath)
+		}
 		if err := saveDefaultConfig(configPath, config); err != nil {
 			return nil, fmt.Errorf("failed to save default config: %w", err)
 		}
@@ -42,11 +164,27 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	if key != nil {
+		if err := verifySignature(key, configPath, file); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := json.Unmarshal(file, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config JSON: %w", err)
 	}
 
-	overrideWithEnv(config)
+	if key != nil {
+		if err := loadTargetVersions(configPath, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.GithubToken != "" || config.RegistrationToken != "" {
+		warnIfReadableByOthers(configPath)
+	}
+
+	overrideWithEnv(config, key != nil)
 
 	return config, nil
 }
@@ -62,26 +200,101 @@ func (c *Config) SaveConfig(configPath string) error {
 		return fmt.Errorf("failed to encode config JSON: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	mode := FileMode
+	if c.GithubToken != "" || c.RegistrationToken != "" {
+		mode = SecretFileMode
+	}
+
+	if err := os.WriteFile(configPath, data, mode); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
+	// WriteFile only applies the mode to new files
+	if err := os.Chmod(configPath, mode); err != nil {
+		return fmt.Errorf("failed to set config file permissions: %w", err)
+	}
+
 	return nil
 }
 
+// warnIfReadableByOthers logs a warning when a config file holding a token is group or world accessible
+func warnIfReadableByOthers(configPath string) {
+	// Permission bits do not reflect access control on Windows
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		log.Printf("Warning: config file %s contains a token but has permissions %04o, consider chmod 600", configPath, perm)
+	}
+}
+
+// SetTargetVersion records the installed version of the named target in the config file.
+// The file is re-read so values coming from environment overrides are not persisted.
+// A signed config file is left untouched and the version is recorded next to it.
+func SetTargetVersion(configPath, name, version string) error {
+	targetMu.Lock()
+	defer targetMu.Unlock()
+
+	key, err := trustedKey()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(file, config); err != nil {
+		return fmt.Errorf("failed to parse config JSON: %w", err)
+	}
+
+	for i := range config.Targets {
+		if config.Targets[i].Name == name {
+			if key != nil {
+				return saveTargetVersion(configPath, name, version)
+			}
+			config.Targets[i].CurrentVersion = version
+			return config.SaveConfig(configPath)
+		}
+	}
+
+	return fmt.Errorf("target %q not found in config", name)
+}
+
 // saveDefaultConfig creates a default config file
 func saveDefaultConfig(configPath string, config *Config) error {
 	return config.SaveConfig(configPath)
 }
 
-// overrideWithEnv updates config values with environment variables if set
-func overrideWithEnv(config *Config) {
+// overrideWithEnv updates config values with environment variables if set. The repository
+// of a signed config is not overridden, as that would bypass the signature.
+func overrideWithEnv(config *Config, signed bool) {
 	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
 		config.GithubToken = token
 	}
 
 	if repo := os.Getenv("GITHUB_REPO"); repo != "" {
-		config.GithubRepo = repo
+		if signed {
+			log.Printf("Warning: ignoring GITHUB_REPO, the config file is signed")
+		} else {
+			config.GithubRepo = repo
+		}
+	}
+
+	if controlToken := os.Getenv("CONTROL_TOKEN"); controlToken != "" {
+		config.ControlToken = controlToken
+	}
+
+	if registrationToken := os.Getenv("REGISTRATION_TOKEN"); registrationToken != "" {
+		config.RegistrationToken = registrationToken
 	}
 
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
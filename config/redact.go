@@ -0,0 +1,94 @@
+// config/redact.go
+package config
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// redacted replaces secrets when a config is shown
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config that is safe to print, with tokens and the
+// passwords and query tokens of URLs replaced
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Targets = slices.Clone(c.Targets)
+	out.ChecksumSources = slices.Clone(c.ChecksumSources)
+
+	for _, token := range []*string{&out.GithubToken, &out.ControlToken, &out.RegistrationToken} {
+		if *token != "" {
+			*token = redacted
+		}
+	}
+
+	out.RegistrationURL = redactURL(out.RegistrationURL)
+	for i, source := range out.ChecksumSources {
+		out.ChecksumSources[i] = redactURL(source)
+	}
+	if c.TUF != nil {
+		tuf := *c.TUF
+		tuf.RepositoryURL = redactURL(tuf.RepositoryURL)
+		out.TUF = &tuf
+	}
+	return &out
+}
+
+// sensitiveParams mark query parameters, such as the signature of a signed URL, whose
+// values are redacted
+var sensitiveParams = []string{"token", "signature", "sig", "credential", "password"}
+
+// redactURL replaces the password and the values of sensitive query parameters of a URL,
+// leaving anything that does not parse alone. Empty values have nothing to hide and are kept.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return rawURL
+	}
+
+	// Redacting by hand keeps placeholders such as {asset} from being escaped
+	authority, path := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority, path = rest[:i], rest[i:]
+	}
+	// Like url.Parse, the userinfo ends at the last "@" of the authority, since a password
+	// may contain one
+	if password, _ := u.User.Password(); password != "" {
+		at := strings.LastIndex(authority, "@")
+		username, _, _ := strings.Cut(authority[:at], ":")
+		authority = username + ":" + redacted + authority[at:]
+	}
+	return scheme + "://" + authority + redactQuery(path)
+}
+
+// redactQuery replaces the values of sensitive parameters in the query of a URL path
+func redactQuery(path string) string {
+	rest, fragment, hasFragment := strings.Cut(path, "#")
+	base, query, ok := strings.Cut(rest, "?")
+	if !ok {
+		return path
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		lower := strings.ToLower(name)
+		for _, sensitive := range sensitiveParams {
+			if value != "" && strings.Contains(lower, sensitive) {
+				params[i] = name + "=" + redacted
+				break
+			}
+		}
+	}
+
+	redactedPath := base + "?" + strings.Join(params, "&")
+	if hasFragment {
+		redactedPath += "#" + fragment
+	}
+	return redactedPath
+}
@@ -0,0 +1,142 @@
+// config/signature.go
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TrustedKey is the base64 encoded ed25519 public key config files must be signed with.
+// It is set at build time with -ldflags "-X github.com/noamstrauss/ota-updater/config.TrustedKey=<key>";
+// when empty, config files are not verified.
+var TrustedKey = ""
+
+// ErrUntrustedConfig is returned when TrustedKey is set but the config file is not signed by it
+var ErrUntrustedConfig = errors.New("config file is not signed by the trusted key")
+
+// signaturePath returns the path of the detached signature of a config file
+func signaturePath(configPath string) string {
+	return configPath + ".sig"
+}
+
+// versionsPath returns the file recording target versions when the config file is signed
+// and so cannot be rewritten
+func versionsPath(configPath string) string {
+	return configPath + ".versions.json"
+}
+
+// trustedKey decodes TrustedKey, returning nil when config files are not verified
+func trustedKey() (ed25519.PublicKey, error) {
+	if TrustedKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(TrustedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trusted key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("trusted key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifySignature checks data against the detached signature next to the config file,
+// given either raw or base64 encoded
+func verifySignature(key ed25519.PublicKey, configPath string, data []byte) error {
+	signature, err := os.ReadFile(signaturePath(configPath))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s is missing", ErrUntrustedConfig, signaturePath(configPath))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config signature: %w", err)
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		if signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err != nil {
+			return fmt.Errorf("%w: malformed signature: %v", ErrUntrustedConfig, err)
+		}
+	}
+
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("%w: signature of %s does not match", ErrUntrustedConfig, configPath)
+	}
+	return nil
+}
+
+// Sign writes the detached signature of the config file at configPath to configPath + ".sig",
+// signing with the ed25519 key derived from a base64 encoded 32 byte seed. It returns the
+// matching public key to build into the updater as TrustedKey.
+func Sign(configPath, seed string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing key: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes, got %d", ed25519.SeedSize, len(raw))
+	}
+	key := ed25519.NewKeyFromSeed(raw)
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := os.WriteFile(signaturePath(configPath), []byte(signature+"\n"), FileMode); err != nil {
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// loadTargetVersions applies the target versions recorded next to a signed config file
+func loadTargetVersions(configPath string, config *Config) error {
+	data, err := os.ReadFile(versionsPath(configPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read target versions: %w", err)
+	}
+
+	var versions map[string]string
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return fmt.Errorf("failed to parse target versions: %w", err)
+	}
+
+	for i := range config.Targets {
+		if version, ok := versions[config.Targets[i].Name]; ok {
+			config.Targets[i].CurrentVersion = version
+		}
+	}
+	return nil
+}
+
+// saveTargetVersion records the installed version of a target next to a signed config file,
+// leaving the signed file itself untouched
+func saveTargetVersion(configPath, name, version string) error {
+	versions := map[string]string{}
+	if data, err := os.ReadFile(versionsPath(configPath)); err == nil {
+		if err := json.Unmarshal(data, &versions); err != nil {
+			return fmt.Errorf("failed to parse target versions: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read target versions: %w", err)
+	}
+	versions[name] = version
+
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode target versions: %w", err)
+	}
+	if err := os.WriteFile(versionsPath(configPath), data, FileMode); err != nil {
+		return fmt.Errorf("failed to write target versions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/noamstrauss/ota-updater/config"
+	"github.com/noamstrauss/ota-updater/updater"
+	"github.com/noamstrauss/ota-updater/version"
+)
+
+// controlResponse is returned by the control endpoint
+type controlResponse struct {
+	Result *updater.Result `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// channelRequest switches the release channel
+type channelRequest struct {
+	Channel string `json:"channel"`
+}
+
+// channelResponse confirms a channel switch and notes what it means for the running version
+type channelResponse struct {
+	Channel string `json:"channel"`
+	Note    string `json:"note,omitempty"`
+}
+
+// deferRequest postpones the update waiting out the apply grace period
+type deferRequest struct {
+	Duration time.Duration `json:"duration"`
+}
+
+// maxControlRequest bounds the size of a control request body
+const maxControlRequest = 1 << 10
+
+// runControlServer serves the control endpoints used to trigger on-demand update checks,
+// apply or defer a waiting update, switch the release channel and show the effective config
+func runControlServer(ctx context.Context, cfg *config.Config, self config.Target) {
+	if cfg.ControlToken == "" {
+		log.Println("Control endpoint disabled: control_token is required")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update/check", requireToken(cfg.ControlToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Println("On-demand update check requested")
+		result, err := checkTarget(ctx, cfg, self)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("Update error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(controlResponse{Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(controlResponse{Result: result})
+
+		if result.Updated {
+			// Restart once the response has been flushed to the caller
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				log.Println("Application updated successfully. Restarting...")
+				if err := updater.RestartApplication(os.Args[0], os.Args[1:]); err != nil {
+					log.Printf("Restart failed: %v", err)
+				}
+			}()
+		}
+	}))
+
+	mux.HandleFunc("/channel", requireToken(cfg.ControlToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req channelRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxControlRequest)).Decode(&req); err != nil || req.Channel == "" || !config.ValidChannel(req.Channel) {
+			http.Error(w, fmt.Sprintf("body must be {\"channel\": %q} or {\"channel\": %q}", config.ChannelStable, config.ChannelBeta), http.StatusBadRequest)
+			return
+		}
+
+		// Checks read the config while they run, so it only changes between them
+		checkMu.Lock()
+		err := config.SetChannel(*configPath, req.Channel)
+		if err == nil {
+			cfg.Channel = req.Channel
+		}
+		checkMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("Failed to switch channel: %v", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, config.ErrSignedConfig) {
+				status = http.StatusConflict
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(controlResponse{Error: err.Error()})
+			return
+		}
+
+		note := channelNote(cfg, req.Channel)
+		log.Printf("Switched to the %s channel, used from the next check", req.Channel)
+		if note != "" {
+			log.Print(note)
+		}
+		json.NewEncoder(w).Encode(channelResponse{Channel: req.Channel, Note: note})
+	}))
+
+	mux.HandleFunc("/config", requireToken(cfg.ControlToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		checkMu.Lock()
+		redacted := cfg.Redacted()
+		checkMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(redacted)
+	}))
+
+	// Not under checkMu, which the check waiting for the update to be applied holds
+	mux.HandleFunc("/update/apply", requireToken(cfg.ControlToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Println("Applying the waiting update now on request")
+		applyControl.ApplyNow()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	mux.HandleFunc("/update/defer", requireToken(cfg.ControlToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req deferRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxControlRequest)).Decode(&req); err != nil || req.Duration <= 0 {
+			http.Error(w, `body must be {"duration": <nanoseconds>}`, http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Deferring the waiting update by %s on request", req.Duration)
+		applyControl.Defer(req.Duration)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	server := &http.Server{
+		Addr:              cfg.ControlAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Control endpoint listening on %s", cfg.ControlAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Control endpoint error: %v", err)
+	}
+}
+
+// channelNote explains what leaving the beta channel means while a pre-release runs.
+// The stable channel may only offer older versions, which AllowDowngrade decides about.
+func channelNote(cfg *config.Config, channel string) string {
+	if channel != config.ChannelStable || !updater.IsPrerelease(version.Version) {
+		return ""
+	}
+	if cfg.AllowDowngrade {
+		return fmt.Sprintf("Pre-release %s will be replaced by the latest stable release on the next check, even if that is older", version.Version)
+	}
+	return fmt.Sprintf("Pre-release %s is kept until a newer stable release appears, since downgrades are not allowed", version.Version)
+}
+
+// requireToken rejects requests that do not carry the bearer token
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
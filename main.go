@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,20 +20,154 @@ import (
 )
 
 var (
-	configPath = flag.String("config", "./config.json", "Path to config file")
+	configPath  = flag.String("config", "./config.json", "Path to config file")
+	listBackups = flag.Bool("list-backups", false, "List the backups of the executable and exit")
+	rollback    = flag.String("rollback", "", "Roll back to a backup by index (1 is the most recent) or version and exit")
+	checkNow    = flag.Bool("check-now", false, "Check for an update once, apply it and exit")
+	verify      = flag.Bool("verify-release", false, "Download and verify the latest release in a sandbox without installing it, print a report and exit")
+	reinstall   = flag.Bool("reinstall", false, "With -check-now, reinstall the latest release even if it is already running")
+	signPath    = flag.String("sign-manifest", "", "Sign a release manifest with the key in MANIFEST_SIGNING_KEY and exit")
+	signConfig  = flag.String("sign-config", "", "Sign a config file with the key in CONFIG_SIGNING_KEY and exit")
+	bundleFrom  = flag.String("create-bundle", "", "Pack a release manifest and its assets into an offline bundle and exit")
+	bundlePath  = flag.String("apply-bundle", "", "Install the update from an offline bundle and exit")
+	printConfig = flag.Bool("print-config", false, "Print the effective config, after defaults and environment overrides, with tokens redacted and exit")
+	showVersion = flag.Bool("version", false, "Print the version and exit")
 )
 
+// checkMu serializes update checks across all targets
+var checkMu sync.Mutex
+
+// applyControl applies or defers the update waiting out apply_grace_period. Checks are
+// serialized, so a single control serves every target.
+var applyControl = updater.NewApplyControl()
+
 func main() {
 	flag.Parse()
+	if *showVersion {
+		fmt.Println(version.Version)
+		return
+	}
+
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Ldate | log.Ltime)
 	log.Printf("Starting application version %s", version.Version)
+	if checksum, err := updater.CurrentBinaryChecksum(); err == nil {
+		log.Printf("Executable sha256 %s", checksum)
+	}
+	if failure := updater.ReplaceFailure(os.Args[0]); failure != "" {
+		log.Printf("Previous update could not be installed: %s", failure)
+	}
+
+	if *listBackups {
+		if err := runListBackups(os.Args[0]); err != nil {
+			log.Fatalf("Listing backups failed: %v", err)
+		}
+		return
+	}
+
+	if *rollback != "" {
+		if err := runRollback(os.Args[0], *rollback); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		log.Printf("Rolled back to %s", *rollback)
+		return
+	}
+
+	if *signPath != "" {
+		publicKey, err := updater.SignManifest(*signPath, os.Getenv("MANIFEST_SIGNING_KEY"))
+		if err != nil {
+			log.Fatalf("Signing failed: %v", err)
+		}
+		log.Printf("Wrote %s.sig, public key %s", *signPath, base64.StdEncoding.EncodeToString(publicKey))
+		return
+	}
+
+	if *signConfig != "" {
+		publicKey, err := config.Sign(*signConfig, os.Getenv("CONFIG_SIGNING_KEY"))
+		if err != nil {
+			log.Fatalf("Signing failed: %v", err)
+		}
+		log.Printf("Wrote %s.sig, public key %s", *signConfig, base64.StdEncoding.EncodeToString(publicKey))
+		return
+	}
+
+	if *bundleFrom != "" {
+		out, err := updater.CreateBundle(*bundleFrom)
+		if err != nil {
+			log.Fatalf("Creating bundle failed: %v", err)
+		}
+		log.Printf("Wrote %s", out)
+		return
+	}
 
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	if *printConfig {
+		data, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode configuration: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	// An unusable key must not silently disable signature checks
+	if cfg.ManifestPublicKey != "" {
+		if _, err := updater.ParsePublicKey(cfg.ManifestPublicKey); err != nil {
+			log.Fatalf("Invalid manifest_public_key: %v", err)
+		}
+	}
+	if !config.ValidChannel(cfg.Channel) {
+		log.Fatalf("Invalid channel %q: must be %q or %q", cfg.Channel, config.ChannelStable, config.ChannelBeta)
+	}
+	for _, pin := range cfg.CertificatePins {
+		if _, err := updater.ParseCertificatePin(pin); err != nil {
+			log.Fatalf("Invalid certificate_pins entry %q: %v", pin, err)
+		}
+	}
+	if c := cfg.Cosign; c != nil && (c.Issuer == "" || c.Identity == "" && c.IdentityRegexp == "") {
+		log.Fatalf("Invalid cosign: issuer and identity or identity_regexp are required")
+	}
+
+	if *checkNow {
+		os.Exit(runCheckNow(cfg))
+	}
+
+	if *verify {
+		os.Exit(runVerifyRelease(cfg))
+	}
+
+	if *bundlePath != "" {
+		runApplyBundle(cfg, *bundlePath)
+		return
+	}
+
+	// Undo directory swaps a crash interrupted, before anything uses the directories
+	for _, target := range updateTargets(cfg) {
+		if err := updater.RecoverSwap(newUpdaterConfig(cfg, target)); err != nil {
+			log.Printf("Failed to recover %s: %v", target.TargetDir, err)
+		}
+	}
+
+	// Revert an update that keeps crashing before it has proven itself
+	if reverted, err := updater.RecordStart(os.Args[0], version.Version, cfg.CrashLimit, cfg.CrashWindow); err != nil {
+		log.Printf("Failed to record start: %v", err)
+	} else if reverted != "" {
+		log.Printf("Version %s crashed %d times after installing, reverted to %s", version.Version, cfg.CrashLimit, reverted)
+		if err := updater.RestartApplication(os.Args[0], os.Args[1:]); err != nil {
+			log.Fatalf("Restart failed: %v", err)
+		}
+	}
+	confirm := time.AfterFunc(cfg.CrashWindow, func() {
+		if err := updater.ConfirmVersion(os.Args[0], version.Version); err != nil {
+			log.Printf("Failed to confirm version: %v", err)
+		}
+	})
+	defer confirm.Stop()
+
 	// Context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -36,54 +175,370 @@ func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
-	// Run updater and application
-	go runUpdateChecker(ctx, cfg)
-	go runApplication(ctx)
+	// Run an updater per target and the application, tracking them so that shutdown can
+	// wait for them to return
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	targets := updateTargets(cfg)
+	for _, target := range targets {
+		run(func() { runUpdateChecker(ctx, cfg, target) })
+	}
+	run(func() { runApplication(ctx) })
+
+	if cfg.ControlAddress != "" {
+		run(func() { runControlServer(ctx, cfg, targets[0]) })
+	}
+
+	if cfg.RegistrationURL != "" {
+		run(func() { runRegistration(ctx, cfg, targets) })
+	}
 
 	// Wait for termination signal
 	<-sigs
 	log.Println("Shutdown signal received, exiting...")
 
-	// Cancel context to stop goroutines
+	// Cancel context to stop goroutines and wait for them, an update in progress included,
+	// but no longer than the shutdown timeout
 	cancel()
 
-	// Give time for cleanup before exit
-	time.Sleep(2 * time.Second)
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(cfg.ShutdownTimeout):
+		log.Printf("Shutdown timed out after %s, exiting anyway", cfg.ShutdownTimeout)
+	}
+
+	if err := updater.RecordExit(os.Args[0]); err != nil {
+		log.Printf("Failed to record exit: %v", err)
+	}
 	log.Println("Application exited")
 }
 
-// runUpdateChecker periodically checks for updates
-func runUpdateChecker(ctx context.Context, cfg *config.Config) {
-	ticker := time.NewTicker(cfg.UpdateInterval)
+// registrationRetryInterval is how long to wait before retrying a failed registration
+const registrationRetryInterval = time.Minute
+
+// runRegistration announces the device to the registration URL, retrying until it succeeds
+func runRegistration(ctx context.Context, cfg *config.Config, targets []config.Target) {
+	registration, err := updater.NewRegistration(cfg.DeviceID, version.Version)
+	if err != nil {
+		log.Printf("Registration skipped: %v", err)
+		return
+	}
+	for _, target := range targets[1:] {
+		if registration.Targets == nil {
+			registration.Targets = make(map[string]string)
+		}
+		registration.Targets[target.Name] = target.CurrentVersion
+	}
+
+	// The control endpoint may switch the channel meanwhile, see checkTarget
+	checkMu.Lock()
+	updateConfig := newUpdaterConfig(cfg, targets[0])
+	checkMu.Unlock()
+	for {
+		err := updater.Register(updateConfig, cfg.RegistrationURL, cfg.RegistrationToken, registration)
+		if err == nil {
+			log.Printf("Registered as %s", registration.DeviceID)
+			return
+		}
+		log.Printf("Registration failed, retrying in %s: %v", registrationRetryInterval, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registrationRetryInterval):
+		}
+	}
+}
+
+// runRollback restores a backup selected by index or, failing that, by version
+func runRollback(executablePath, target string) error {
+	if index, err := strconv.Atoi(target); err == nil {
+		return updater.Rollback(executablePath, index)
+	}
+	return updater.RollbackToVersion(executablePath, target)
+}
+
+// runListBackups prints a line per backup of the executable, most recent first
+func runListBackups(executablePath string) error {
+	backups, err := updater.ListBackups(executablePath)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		log.Printf("No backups of %s", executablePath)
+		return nil
+	}
+
+	for _, backup := range backups {
+		version := backup.Version
+		if version == "" {
+			version = "unknown"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", backup.Index, version, backup.Created.Format(time.RFC3339), backup.SHA256, backup.Path)
+	}
+	return nil
+}
+
+// runApplyBundle installs the update in an offline bundle. The running application is not
+// restarted, as it may be supervised separately.
+func runApplyBundle(cfg *config.Config, path string) {
+	result, err := updater.ApplyBundle(newUpdaterConfig(cfg, updateTargets(cfg)[0]), path)
+	if err != nil {
+		log.Fatalf("Applying bundle failed: %v", err)
+	}
+
+	if !result.Updated {
+		log.Printf("Version %s is already installed", result.Version)
+		return
+	}
+	log.Printf("Installed version %s, restart the application to run it", result.Version)
+}
+
+// updateTargets returns the application itself followed by any extra targets from the config
+func updateTargets(cfg *config.Config) []config.Target {
+	targets := []config.Target{{
+		GithubRepo:     cfg.GithubRepo,
+		FallbackRepos:  cfg.FallbackRepos,
+		ExecutablePath: os.Args[0],
+		CurrentVersion: version.Version,
+		PinnedVersion:  cfg.PinnedVersion,
+		VersionProbe:   cfg.VersionProbe,
+		UpdateInterval: cfg.UpdateInterval,
+	}}
+
+	for _, target := range cfg.Targets {
+		if target.UpdateInterval == 0 {
+			target.UpdateInterval = cfg.UpdateInterval
+		}
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// runUpdateChecker periodically checks for updates of a single target
+func runUpdateChecker(ctx context.Context, cfg *config.Config, target config.Target) {
+	ticker := time.NewTicker(target.UpdateInterval)
 	defer ticker.Stop()
 
+	// The application itself is the only target without a name
+	self := target.Name == ""
+	prefix := ""
+	if !self {
+		prefix = "[" + target.Name + "] "
+	}
+
+	offline := false
+
+	// A deferred critical update is checked for more often, so that it is applied soon
+	// after the maintenance window opens
+	critical := false
+	interval := func() time.Duration {
+		if critical {
+			return cfg.CriticalInterval
+		}
+		return target.UpdateInterval
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Stopping update checker...")
+			log.Printf("%sStopping update checker...", prefix)
 			return
 		case <-ticker.C:
-			log.Println("Checking for updates...")
-			updateConfig := updater.Config{
-				CurrentVersion: version.Version,
-				GithubRepo:     cfg.GithubRepo,
-				GithubToken:    cfg.GithubToken,
-				ExecutablePath: os.Args[0],
+			if cfg.ProbeAddress != "" && !updater.Reachable(cfg.ProbeAddress, cfg.ProbeTimeout) {
+				// Skip quietly while offline, only logging the transition
+				if !offline {
+					log.Printf("%sUpdate host %s unreachable, skipping checks until it is back", prefix, cfg.ProbeAddress)
+					offline = true
+					if cfg.OfflineRetryInterval > 0 {
+						ticker.Reset(cfg.OfflineRetryInterval)
+					}
+				}
+				continue
+			}
+			if offline {
+				log.Printf("%sUpdate host %s reachable again", prefix, cfg.ProbeAddress)
+				offline = false
+				ticker.Reset(interval())
 			}
 
-			hasUpdate, err := updater.CheckAndUpdate(updateConfig)
+			log.Printf("%sChecking for updates...", prefix)
+			result, err := checkTarget(ctx, cfg, target)
 			if err != nil {
-				log.Printf("Update error: %v", err)
-			} else if hasUpdate {
+				log.Printf("%sUpdate error: %v", prefix, err)
+				continue
+			}
+			if urgent := result.Deferred && result.Urgency == updater.UrgencyCritical && cfg.CriticalInterval > 0; urgent != critical {
+				critical = urgent
+				ticker.Reset(interval())
+				if critical {
+					log.Printf("%sCritical update %s pending, checking every %s", prefix, result.Version, cfg.CriticalInterval)
+				}
+			}
+			if result.Deferred {
+				switch {
+				case !result.DownloadAt.IsZero():
+					log.Printf("%sUpdate %s will be downloaded after %s", prefix, result.Version, result.DownloadAt.Format(time.RFC3339))
+				case !result.ApplyAt.IsZero():
+					log.Printf("%sUpdate %s will be applied after %s", prefix, result.Version, result.ApplyAt.Format(time.RFC3339))
+				default:
+					log.Printf("%sUpdate %s deferred until the battery is charged", prefix, result.Version)
+				}
+				if time.Now().Before(result.EnforceAfter) {
+					log.Printf("%sUpdate %s will be required after %s", prefix, result.Version, result.EnforceAfter.Format(time.RFC3339))
+				}
+				continue
+			}
+			if !result.Updated {
+				log.Printf("%sNo updates available", prefix)
+				continue
+			}
+
+			t := result.Timings
+			log.Printf("%sUpdate took %s (check %s, download %s, verify %s, apply %s)", prefix, t.Total, t.Check, t.Download, t.Verify, t.Apply)
+
+			if self {
 				log.Println("Application updated successfully. Restarting...")
-				updater.RestartApplication(os.Args[0], os.Args[1:])
-			} else {
-				log.Println("No updates available")
+				if err := updater.RestartApplication(os.Args[0], os.Args[1:]); err != nil {
+					log.Printf("Restart failed: %v", err)
+				}
+				continue
+			}
+
+			log.Printf("%sUpdated to version %s", prefix, result.Version)
+			target.CurrentVersion = result.Version
+			if err := config.SetTargetVersion(*configPath, target.Name, result.Version); err != nil {
+				log.Printf("%sFailed to record installed version: %v", prefix, err)
 			}
 		}
 	}
 }
 
+// newUpdaterConfig builds the updater configuration for a single target
+func newUpdaterConfig(cfg *config.Config, target config.Target) updater.Config {
+	updateConfig := updater.Config{
+		Name:                target.Name,
+		CurrentVersion:      target.CurrentVersion,
+		GithubRepo:          target.GithubRepo,
+		GithubToken:         cfg.GithubToken,
+		FallbackRepos:       target.FallbackRepos,
+		ExecutablePath:      target.ExecutablePath,
+		SourceArchive:       target.SourceArchive,
+		TargetDir:           target.TargetDir,
+		ReadyMarker:         target.ReadyMarker,
+		DownloadRateLimit:   cfg.DownloadRateLimit,
+		ParallelHashSize:    cfg.ParallelHashSize,
+		MinDownloadRate:     cfg.MinDownloadRate,
+		StallPeriod:         cfg.StallPeriod,
+		PinnedVersion:       target.PinnedVersion,
+		VersionProbe:        target.VersionProbe,
+		VersionSource:       target.VersionSource,
+		VersionFile:         target.VersionFile,
+		AllowDowngrade:      cfg.AllowDowngrade,
+		RepairCorrupted:     cfg.RepairCorrupted,
+		SkipVersions:        cfg.SkipVersions,
+		IncludePrerelease:   cfg.IncludesPrereleases(),
+		ReleaseScanDepth:    cfg.ReleaseScanDepth,
+		BackupCount:         cfg.BackupCount,
+		DisableBackup:       !cfg.CreateBackup,
+		SymlinkStrategy:     cfg.SymlinkStrategy,
+		SlowPhaseThreshold:  cfg.SlowUpdateWarning,
+		MaxUpdatesPerWindow: cfg.MaxUpdatesPerWindow,
+		UpdateBudgetWindow:  cfg.UpdateBudgetWindow,
+		RestartCooldown:     cfg.RestartCooldown,
+		ApplyGracePeriod:    cfg.ApplyGracePeriod,
+		MaxApplyDeferral:    cfg.MaxApplyDeferral,
+		ApplyControl:        applyControl,
+		FailureBackoff:      cfg.FailureBackoff,
+		AuditLog:            cfg.AuditLog,
+		Cosign:              updaterCosign(cfg.Cosign),
+		MaintenanceWindow:   updaterWindow(cfg.MaintenanceWindow),
+		DownloadWindow:      updaterWindow(cfg.DownloadWindow),
+		ClockSkewTolerance:  cfg.ClockSkewTolerance,
+		MinBatteryPercent:   cfg.MinBatteryPercent,
+		UseServerTime:       cfg.UseServerTime,
+		StagingDir:          cfg.StagingDir,
+		ChecksumAlgorithm:   cfg.ChecksumAlgorithm,
+		ChecksumSources:     cfg.ChecksumSources,
+		AssetPreference:     cfg.AssetPreference,
+		AssetVersionPattern: cfg.AssetVersionPattern,
+		OnMissingAsset:      cfg.OnMissingAsset,
+		AllowSetuid:         cfg.AllowSetuid,
+		AllowInstallScripts: cfg.AllowInstallScripts,
+		ScriptTimeout:       cfg.InstallScriptTimeout,
+		VersionProbeTimeout: cfg.VersionProbeTimeout,
+		Debug:               cfg.LogLevel == "debug",
+	}
+
+	if cfg.ManifestPublicKey != "" {
+		updateConfig.ManifestPublicKey, _ = updater.ParsePublicKey(cfg.ManifestPublicKey)
+	}
+	for _, pin := range cfg.CertificatePins {
+		parsed, _ := updater.ParseCertificatePin(pin)
+		updateConfig.CertificatePins = append(updateConfig.CertificatePins, parsed)
+	}
+
+	// Only the application itself is relaunched with its own arguments. Targets in a TUF
+	// repository or directory source are not told apart by application, so they only serve
+	// the application too.
+	if target.Name == "" {
+		updateConfig.RestartArgs = os.Args[1:]
+		updateConfig.TUF = updaterTUF(cfg.TUF)
+		updateConfig.DirectorySource = cfg.DirectorySource
+	}
+
+	return updateConfig
+}
+
+// checkTarget runs a single check-and-apply for target. Checks are serialized so that
+// scheduled and on-demand checks never install updates concurrently. Commands the check
+// runs are killed once ctx is cancelled.
+func checkTarget(ctx context.Context, cfg *config.Config, target config.Target) (*updater.Result, error) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+
+	updateConfig := newUpdaterConfig(cfg, target)
+	updateConfig.Context = ctx
+	return updater.CheckAndUpdate(updateConfig)
+}
+
+// updaterWindow converts a configured time window for the updater package
+func updaterWindow(w *config.Window) *updater.Window {
+	if w == nil {
+		return nil
+	}
+	return &updater.Window{Start: w.Start, End: w.End, Timezone: w.Timezone}
+}
+
+// updaterTUF converts the configured TUF repository for the updater package
+func updaterTUF(t *config.TUF) *updater.TUFConfig {
+	if t == nil {
+		return nil
+	}
+	return &updater.TUFConfig{RepositoryURL: t.RepositoryURL, RootPath: t.RootPath, MetadataDir: t.MetadataDir}
+}
+
+// updaterCosign converts the configured Sigstore signer for the updater package
+func updaterCosign(c *config.Cosign) *updater.CosignConfig {
+	if c == nil {
+		return nil
+	}
+	return &updater.CosignConfig{Identity: c.Identity, IdentityRegexp: c.IdentityRegexp, Issuer: c.Issuer, TrustedRootPath: c.TrustedRootPath}
+}
+
 func runApplication(ctx context.Context) {
 	log.Println("Application is running...")
 
@@ -0,0 +1,97 @@
+// updater/apply.go
+package updater
+
+import (
+	"context"
+	"time"
+)
+
+// ApplyControl lets an embedding application decide when a downloaded update is applied
+// during the ApplyGracePeriod, for example to avoid restarting while the user is mid-task
+type ApplyControl struct {
+	now      chan struct{}
+	deferral chan time.Duration
+}
+
+// NewApplyControl returns a control to pass as Config.ApplyControl
+func NewApplyControl() *ApplyControl {
+	return &ApplyControl{
+		now:      make(chan struct{}, 1),
+		deferral: make(chan time.Duration, 1),
+	}
+}
+
+// ApplyNow applies a waiting update immediately. Call it when the user asks for the update
+// or when the application becomes idle. It has no effect when no update is waiting.
+func (a *ApplyControl) ApplyNow() {
+	select {
+	case a.now <- struct{}{}:
+	default:
+	}
+}
+
+// Defer postpones a waiting update by d from now, but never beyond MaxApplyDeferral
+func (a *ApplyControl) Defer(d time.Duration) {
+	select {
+	case <-a.deferral:
+	default:
+	}
+	a.deferral <- d
+}
+
+// drain discards signals sent while no update was waiting
+func (a *ApplyControl) drain() {
+	for {
+		select {
+		case <-a.now:
+		case <-a.deferral:
+		default:
+			return
+		}
+	}
+}
+
+// awaitApply blocks for the grace period before a verified update is applied, returning
+// early on ApplyNow and later on Defer, up to the maximum deferral. It returns the cause
+// of Context being cancelled while waiting, in which case the update is not applied.
+func (c Config) awaitApply(version string) error {
+	if c.ApplyGracePeriod <= 0 {
+		return nil
+	}
+
+	// Without a control both channels stay nil and only the grace period ends the wait
+	var now chan struct{}
+	var deferral chan time.Duration
+	if c.ApplyControl != nil {
+		c.ApplyControl.drain()
+		now, deferral = c.ApplyControl.now, c.ApplyControl.deferral
+	}
+
+	limit := time.Now().Add(max(c.MaxApplyDeferral, c.ApplyGracePeriod))
+	if c.OnUpdateReady != nil {
+		c.OnUpdateReady(version)
+	}
+	c.logf("Update %s ready, applying in %s", version, c.ApplyGracePeriod)
+
+	ctx := c.baseContext()
+	timer := time.NewTimer(c.ApplyGracePeriod)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-now:
+			c.logf("Applying update %s now", version)
+			return nil
+		case d := <-deferral:
+			deadline := time.Now().Add(d)
+			if deadline.After(limit) {
+				deadline = limit
+			}
+			timer.Reset(time.Until(deadline))
+			c.logf("Update %s deferred until %s", version, deadline.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestAwaitApplyStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	config := Config{
+		Context:          ctx,
+		ApplyGracePeriod: time.Hour,
+		Logger:           log.New(io.Discard, "", 0),
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- config.awaitApply("1.1.0") }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("awaitApply() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("awaitApply() kept waiting after the context was cancelled")
+	}
+}
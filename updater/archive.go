@@ -0,0 +1,349 @@
+// updater/archive.go
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// Source archive kinds GitHub generates for every release
+const (
+	Tarball = "tarball"
+	Zipball = "zipball"
+)
+
+// applySourceArchive downloads the source archive of release and replaces TargetDir with
+// its contents. The previous directory is kept as TargetDir + ".old" unless backups are disabled.
+func applySourceArchive(config Config, release *github.RepositoryRelease, version string, timings *Timings) (*Result, error) {
+	if config.TargetDir == "" {
+		return nil, fmt.Errorf("source archive updates require a target directory")
+	}
+
+	var archiveURL string
+	switch config.SourceArchive {
+	case Tarball:
+		archiveURL = release.GetTarballURL()
+	case Zipball:
+		archiveURL = release.GetZipballURL()
+	default:
+		return nil, fmt.Errorf("unknown source archive %q, should be %q or %q", config.SourceArchive, Tarball, Zipball)
+	}
+	if archiveURL == "" {
+		return nil, fmt.Errorf("release %s has no %s", release.GetTagName(), config.SourceArchive)
+	}
+
+	// Nothing is staged for archives, so while the update is held back the download waits as well
+	selected := &selectedAsset{name: config.SourceArchive, source: archiveURL}
+	if applyAt, err := config.applyAt(version, selected); err != nil || !applyAt.IsZero() {
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Version: version, Deferred: true, ApplyAt: applyAt}, nil
+	}
+
+	downloadStart := time.Now()
+	tempPath, digest, err := downloadUpdate(config, archiveURL, SHA256, false)
+	timings.Download = time.Since(downloadStart)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempPath)
+
+	// The digest is only recorded, source archives publish none to verify it against
+	result, err := installSourceArchive(config, version, selected, tempPath, timings)
+	config.audit(version, selected, digest, result, err)
+	return result, err
+}
+
+// installSourceArchive runs the verifiers on the archive at tempPath and swaps it in
+func installSourceArchive(config Config, version string, selected *selectedAsset, tempPath string, timings *Timings) (*Result, error) {
+	if err := runVerifiers(config, tempPath, version, selected); err != nil {
+		return nil, err
+	}
+
+	// The download is removed once the install ends, so there is nothing to keep
+	return installReady(config, version, selected, timings, installSteps{
+		apply:    func() error { return replaceDirectory(config, tempPath) },
+		keep:     func() {},
+		rollback: func() error { return restoreDirectory(config) },
+	})
+}
+
+// replaceDirectory extracts the archive at archivePath next to TargetDir and swaps it in
+func replaceDirectory(config Config, archivePath string) error {
+	// Swapping the real directory keeps a symlinked TargetDir a link
+	targetDir := resolveLink(filepath.Clean(config.TargetDir))
+	newDir, oldDir := targetDir+".new", targetDir+".old"
+
+	if err := RecoverSwap(config); err != nil {
+		return err
+	}
+
+	os.RemoveAll(newDir)
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	var err error
+	if config.SourceArchive == Zipball {
+		err = extractZip(config, archivePath, newDir)
+	} else {
+		err = extractTarGz(config, archivePath, newDir)
+	}
+	if err != nil {
+		os.RemoveAll(newDir)
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	os.RemoveAll(oldDir)
+	var renames []journalRename
+	if _, err := os.Stat(targetDir); err == nil {
+		renames = append(renames, journalRename{From: targetDir, To: oldDir})
+	}
+	renames = append(renames, journalRename{From: newDir, To: targetDir})
+
+	// The journal lets RecoverSwap undo a swap interrupted between the two renames
+	if err := swapJournaled(config.journalPath(), renames); err != nil {
+		os.RemoveAll(newDir)
+		return fmt.Errorf("failed to replace directory: %w", err)
+	}
+
+	if config.DisableBackup {
+		os.RemoveAll(oldDir)
+	}
+	return nil
+}
+
+// restoreDirectory swaps the previous TargetDir, kept as TargetDir + ".old", back in
+func restoreDirectory(config Config) error {
+	targetDir := resolveLink(filepath.Clean(config.TargetDir))
+	failedDir, oldDir := targetDir+".new", targetDir+".old"
+	if _, err := os.Stat(oldDir); err != nil {
+		return fmt.Errorf("no previous directory to restore: %w", err)
+	}
+
+	os.RemoveAll(failedDir)
+	renames := []journalRename{{From: targetDir, To: failedDir}, {From: oldDir, To: targetDir}}
+	if err := swapJournaled(config.journalPath(), renames); err != nil {
+		return fmt.Errorf("failed to restore directory: %w", err)
+	}
+	return os.RemoveAll(failedDir)
+}
+
+// archiveEntryPath maps an archive entry to a path below dir. GitHub source archives wrap
+// everything in a single "<owner>-<repo>-<commit>/" directory, which is stripped. An empty
+// path means the entry is that directory itself. Cleaning the name as an absolute path
+// keeps entries like "../x" from escaping dir.
+func archiveEntryPath(dir, name string) string {
+	_, name, _ = strings.Cut(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	if name == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// extractTarGz extracts a gzip compressed tarball into dir. Symlinks may only resolve
+// within dir, and no entry is ever written through a link leading out of it.
+func extractTarGz(config Config, archivePath, dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return checkSymlinks(root)
+		}
+		if err != nil {
+			return err
+		}
+
+		target := archiveEntryPath(dir, header.Name)
+		if target == "" {
+			continue
+		}
+
+		// Links extracted earlier are followed, as they would be when writing the entry
+		if err := checkParentWithin(root, target); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+		// An existing link is replaced rather than written through
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			mode := header.FileInfo().Mode()
+			err = writeArchiveFile(target, tr, mode.Perm()|config.setuidBits(header.Name, mode))
+		case tar.TypeSymlink:
+			// Links may only point within the extracted tree
+			if filepath.IsAbs(header.Linkname) || !isWithin(dir, filepath.Join(filepath.Dir(target), header.Linkname)) {
+				return fmt.Errorf("symlink %s points outside the archive", header.Name)
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+}
+
+// extractZip extracts a zip archive into dir
+func extractZip(config Config, archivePath, dir string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target := archiveEntryPath(dir, f.Name)
+		if target == "" {
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+		err = writeArchiveFile(target, rc, f.Mode().Perm()|config.setuidBits(f.Name, f.Mode()))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeArchiveFile writes the contents of r to dst, creating parent directories as needed
+func writeArchiveFile(dst string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Creating the file does not reliably apply setuid and setgid
+	if mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		return os.Chmod(dst, mode)
+	}
+	return nil
+}
+
+// setuidBits returns the setuid and setgid bits of an archive entry's mode that may be
+// kept. Installing a privileged binary from a remote source is dangerous, so unless
+// AllowSetuid is set they are stripped.
+func (c Config) setuidBits(name string, mode os.FileMode) os.FileMode {
+	bits := mode & (os.ModeSetuid | os.ModeSetgid)
+	if bits != 0 && !c.AllowSetuid {
+		c.logf("Stripping setuid/setgid bits from %s", name)
+		return 0
+	}
+	return bits
+}
+
+// checkParentWithin returns an error unless the parent directory of target, with every
+// link on the way resolved, lies within root. Missing directories are created inside
+// their nearest existing ancestor, so that is the one checked.
+func checkParentWithin(root, target string) error {
+	parent := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(parent); err == nil {
+			break
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			break
+		}
+		parent = next
+	}
+
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return err
+	}
+	if !isWithin(root, resolved) {
+		return fmt.Errorf("%s leads outside the archive", parent)
+	}
+	return nil
+}
+
+// checkSymlinks returns an error when a symlink extracted into root resolves outside of
+// it. Each link was checked on its own when extracted, but a chain of links, such as
+// "d -> ." followed by "e -> d/..", can still lead out once all are in place. Dangling
+// links point at nothing and are left alone.
+func checkSymlinks(root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return nil
+		}
+		if !isWithin(root, resolved) {
+			return fmt.Errorf("symlink %s points outside the archive", p)
+		}
+		return nil
+	})
+}
+
+// isWithin reports whether p lies inside dir
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,275 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// archiveEntry is a file, directory or symlink in a test archive
+type archiveEntry struct {
+	name string
+	// mode holds the type and permissions, files without permissions get 0644
+	mode fs.FileMode
+	// body is the contents of a file or the target of a symlink
+	body string
+}
+
+// tarGz returns a gzip compressed tarball of entries
+func tarGz(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: tarMode(e.mode)}
+		switch {
+		case e.mode.IsDir():
+			header.Typeflag = tar.TypeDir
+		case e.mode&fs.ModeSymlink != 0:
+			header.Typeflag, header.Linkname = tar.TypeSymlink, e.body
+		default:
+			header.Typeflag, header.Size = tar.TypeReg, int64(len(e.body))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarMode returns the tar header mode of an entry's permissions and setuid and setgid bits
+func tarMode(mode fs.FileMode) int64 {
+	bits := int64(mode.Perm())
+	if bits == 0 {
+		bits = 0644
+	}
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	return bits
+}
+
+// zipArchive returns a zip archive of the files and directories among entries
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := e.mode
+		if mode.Perm() == 0 {
+			mode |= 0644
+		}
+		header.SetMode(mode)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		// tarOnly marks cases about symlinks, which zip archives do not extract
+		tarOnly     bool
+		allowSetuid bool
+		wantErr     bool
+		// want maps paths below the extracted directory to their contents, wantMode to
+		// their permissions and setuid and setgid bits
+		want     map[string]string
+		wantMode map[string]fs.FileMode
+	}{
+		{
+			name: "plain tree",
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/", mode: fs.ModeDir | 0755},
+				{name: "owner-app-1a2b3c/bin/", mode: fs.ModeDir | 0755},
+				{name: "owner-app-1a2b3c/bin/app", mode: 0755, body: "app"},
+				{name: "owner-app-1a2b3c/README", body: "readme"},
+			},
+			want:     map[string]string{"bin/app": "app", "README": "readme"},
+			wantMode: map[string]fs.FileMode{"bin/app": 0755, "README": 0644},
+		},
+		{
+			name: "parent directory entries stay inside",
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/../../escaped", body: "evil"},
+				{name: "owner-app-1a2b3c/sub/../../../escaped", body: "evil"},
+				{name: "owner-app-1a2b3c/a/../kept", body: "kept"},
+			},
+			want: map[string]string{"kept": "kept"},
+		},
+		{
+			name: "absolute paths stay inside",
+			entries: []archiveEntry{
+				{name: "/owner-app-1a2b3c/abs", body: "abs"},
+				{name: "/../escaped", body: "evil"},
+			},
+			want: map[string]string{"abs": "abs"},
+		},
+		{
+			name:    "absolute symlink target",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/passwd", mode: fs.ModeSymlink, body: "/etc/passwd"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "relative symlink leading outside",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/up", mode: fs.ModeSymlink, body: "../.."},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "symlink inside the tree",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/bin/app", mode: 0755, body: "app"},
+				{name: "owner-app-1a2b3c/app", mode: fs.ModeSymlink, body: "bin/app"},
+			},
+			want: map[string]string{"bin/app": "app", "app": "app"},
+		},
+		{
+			name:    "link to link chain leading outside",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/d", mode: fs.ModeSymlink, body: "."},
+				{name: "owner-app-1a2b3c/e", mode: fs.ModeSymlink, body: "d/.."},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "file written through an extracted link",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/d", mode: fs.ModeSymlink, body: "."},
+				{name: "owner-app-1a2b3c/e", mode: fs.ModeSymlink, body: "d/.."},
+				{name: "owner-app-1a2b3c/e/escaped", body: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "link replaced by a file",
+			tarOnly: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/target", body: "original"},
+				{name: "owner-app-1a2b3c/link", mode: fs.ModeSymlink, body: "target"},
+				{name: "owner-app-1a2b3c/link", body: "replaced"},
+			},
+			want: map[string]string{"target": "original", "link": "replaced"},
+		},
+		{
+			name: "setuid and setgid stripped",
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/suid", mode: 0755 | fs.ModeSetuid, body: "suid"},
+				{name: "owner-app-1a2b3c/sgid", mode: 0755 | fs.ModeSetgid, body: "sgid"},
+			},
+			want:     map[string]string{"suid": "suid", "sgid": "sgid"},
+			wantMode: map[string]fs.FileMode{"suid": 0755, "sgid": 0755},
+		},
+		{
+			name:        "setuid kept when allowed",
+			allowSetuid: true,
+			entries: []archiveEntry{
+				{name: "owner-app-1a2b3c/suid", mode: 0755 | fs.ModeSetuid, body: "suid"},
+			},
+			want:     map[string]string{"suid": "suid"},
+			wantMode: map[string]fs.FileMode{"suid": 0755 | fs.ModeSetuid},
+		},
+	}
+
+	kinds := []struct {
+		name    string
+		archive func(*testing.T, ...archiveEntry) []byte
+		extract func(Config, string, string) error
+	}{
+		{name: Tarball, archive: tarGz, extract: extractTarGz},
+		{name: Zipball, archive: zipArchive, extract: extractZip},
+	}
+
+	for _, kind := range kinds {
+		for _, tt := range tests {
+			if tt.tarOnly && kind.name == Zipball {
+				continue
+			}
+			t.Run(kind.name+"/"+tt.name, func(t *testing.T) {
+				if runtime.GOOS == "windows" && (tt.tarOnly || tt.wantMode != nil) {
+					t.Skip("symlinks and permission bits are not extracted on Windows")
+				}
+
+				archivePath := filepath.Join(t.TempDir(), "archive")
+				if err := os.WriteFile(archivePath, kind.archive(t, tt.entries...), 0644); err != nil {
+					t.Fatal(err)
+				}
+				// The extracted directory sits a few levels deep, so escapes have somewhere to go
+				parent := t.TempDir()
+				dir := filepath.Join(parent, "a", "b", "out")
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				config := Config{AllowSetuid: tt.allowSetuid, Logger: log.New(io.Discard, "", 0)}
+				err := kind.extract(config, archivePath, dir)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("extract() error = %v, want error %v", err, tt.wantErr)
+				}
+
+				// Nothing may be written next to or above the extracted directory
+				filepath.WalkDir(parent, func(p string, d fs.DirEntry, err error) error {
+					if err == nil && !d.IsDir() && !isWithin(dir, p) {
+						t.Errorf("%s written outside the extracted directory", p)
+					}
+					return err
+				})
+
+				for name, contents := range tt.want {
+					assertFile(t, filepath.Join(dir, filepath.FromSlash(name)), contents)
+				}
+				for name, mode := range tt.wantMode {
+					info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid); got != mode {
+						t.Errorf("%s has mode %s, want %s", name, got, mode)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,414 @@
+// updater/assets.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// ErrNoAsset is returned when a release has no asset for the running platform and architecture
+var ErrNoAsset = errors.New("no suitable asset found")
+
+// Behaviors when a release has no asset for the running platform, see Config.OnMissingAsset
+const (
+	MissingAssetError = "error"
+	MissingAssetSkip  = "skip"
+	MissingAssetWait  = "wait"
+)
+
+// missingAsset handles a release without an asset for this platform according to
+// config.OnMissingAsset, logging only the first time a version is found lacking one
+func missingAsset(config Config, version string, err error) (*Result, error) {
+	switch config.OnMissingAsset {
+	case "", MissingAssetError:
+		return nil, err
+	case MissingAssetSkip, MissingAssetWait:
+	default:
+		return nil, fmt.Errorf("unknown missing asset behavior %q", config.OnMissingAsset)
+	}
+
+	state, stateErr := LoadState(config.ExecutablePath)
+	if stateErr != nil {
+		return nil, stateErr
+	}
+
+	if state.MissingAsset != version {
+		config.logf("Version %s has no asset for %s/%s (%v), not updating", version, runtime.GOOS, runtime.GOARCH, err)
+		state.MissingAsset = version
+		if err := state.save(config.ExecutablePath); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{Version: config.CurrentVersion}, nil
+}
+
+// selectedAsset is the asset chosen for download together with what it is verified against
+type selectedAsset struct {
+	asset     *github.ReleaseAsset
+	name      string
+	algorithm string
+	checksum  string
+	size      int64
+	urgency   string
+	// enforceAfter is when the release becomes mandatory, zero if it never does
+	enforceAfter time.Time
+	// source is recorded in the audit log for updates not downloaded from a release asset
+	source string
+	// universal is set for macOS universal binaries, which must contain a slice for the
+	// running architecture
+	universal bool
+	// extra are digests from ChecksumSources in other algorithms, also checked after download
+	extra []sourceDigest
+	// preInstall and postInstall are the release's install scripts, if any
+	preInstall  *installScript
+	postInstall *installScript
+	// cosignBundle is the Sigstore bundle of the asset, set when Cosign is configured
+	cosignBundle *github.ReleaseAsset
+}
+
+// resolveAsset selects the asset to install from release, using its manifest when present
+func resolveAsset(config Config, release *github.RepositoryRelease, version string) (*selectedAsset, error) {
+	manifest, err := fetchManifest(config, release)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		// Sidecar checksums are unsigned, so they cannot vouch for the binary
+		if config.ManifestPublicKey != nil {
+			return nil, fmt.Errorf("%w: release %s has no %s", ErrUnsignedRelease, release.GetTagName(), manifestAssetName)
+		}
+
+		asset, err := findAsset(config, release.Assets)
+		if err != nil {
+			return nil, err
+		}
+
+		// GitHub's own digest needs no checksum asset to be published alongside
+		algorithm, checksum, err := fetchAssetDigest(config, asset)
+		if err != nil {
+			return nil, err
+		}
+		if checksum == "" {
+			if algorithm, checksum, err = fetchSidecarChecksum(config, release.Assets, asset); err != nil {
+				return nil, err
+			}
+		}
+		selected := &selectedAsset{asset: asset, name: asset.GetName(), algorithm: algorithm, checksum: checksum, size: assetSize(asset), universal: isUniversalAsset(asset.GetName())}
+		if err := findCosignBundle(config, release, selected); err != nil {
+			return nil, err
+		}
+		return selected, nil
+	}
+
+	// The manifest is authoritative for asset selection and verification
+	selected, err := selectFromManifest(config, manifest, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if selected.asset = assetByName(release.Assets, selected.name); selected.asset == nil {
+		return nil, fmt.Errorf("%w: manifest asset %s not in release", ErrNoAsset, selected.name)
+	}
+	if selected.size == 0 {
+		selected.size = assetSize(selected.asset)
+	}
+	if selected.checksum == "" {
+		if selected.algorithm, selected.checksum, err = fetchAssetDigest(config, selected.asset); err != nil {
+			return nil, err
+		}
+	}
+	if err := resolveScripts(config, release, manifest.find(runtime.GOOS, runtime.GOARCH), selected); err != nil {
+		return nil, err
+	}
+	if err := findCosignBundle(config, release, selected); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// assetSize returns the size the release declares for asset, or 0 for a gzipped asset, whose
+// declared size is that of the compressed file while downloads are verified decompressed
+func assetSize(asset *github.ReleaseAsset) int64 {
+	if isGzipAsset(asset.GetName()) {
+		return 0
+	}
+	return int64(asset.GetSize())
+}
+
+// minVersionError is returned when a release can only be installed over its min_version or later
+type minVersionError struct {
+	version    string
+	minVersion string
+}
+
+func (e *minVersionError) Error() string {
+	return fmt.Sprintf("version %s requires at least version %s to be installed", e.version, e.minVersion)
+}
+
+// selectFromManifest picks the manifest entry for the running platform and architecture,
+// checking that it may be installed on this host
+func selectFromManifest(config Config, manifest *Manifest, version string) (*selectedAsset, error) {
+	entry := manifest.find(runtime.GOOS, runtime.GOARCH)
+	if entry == nil {
+		return nil, fmt.Errorf("%w: manifest has no asset for %s/%s", ErrNoAsset, runtime.GOOS, runtime.GOARCH)
+	}
+	if entry.MinVersion != "" && compareVersions(config.CurrentVersion, entry.MinVersion) < 0 {
+		return nil, &minVersionError{version: version, minVersion: strings.TrimPrefix(entry.MinVersion, "v")}
+	}
+	if err := checkRequirements(config, entry.Requirements); err != nil {
+		return nil, err
+	}
+
+	algorithm, checksum := manifestChecksum(entry, config.ChecksumAlgorithm)
+	if config.ManifestPublicKey != nil && checksum == "" {
+		return nil, fmt.Errorf("%w: manifest lists no checksum for %s", ErrUnsignedRelease, entry.Name)
+	}
+	selected := &selectedAsset{name: entry.Name, algorithm: algorithm, checksum: checksum, size: entry.Size, urgency: manifest.Urgency, enforceAfter: manifest.EnforceAfter}
+	selected.universal = isUniversalArch(entry.Platform, entry.Arch)
+	selected.enforce(config)
+	return selected, nil
+}
+
+// enforce makes the update critical once its enforce_after deadline has passed
+func (s *selectedAsset) enforce(config Config) {
+	if s.enforced(config) {
+		s.urgency = UrgencyCritical
+	}
+}
+
+// enforced reports whether the update is mandatory, which lets it skip the download and
+// maintenance windows and the update budget
+func (s *selectedAsset) enforced(config Config) bool {
+	return !s.enforceAfter.IsZero() && !config.now().Before(s.enforceAfter)
+}
+
+// resolveWithFallbacks selects the asset to install from release, searching FallbackRepos
+// in order when release has none for this platform
+func resolveWithFallbacks(ctx context.Context, client *github.Client, config Config, release *github.RepositoryRelease, version string) (*selectedAsset, error) {
+	selected, err := resolveAsset(config, release, version)
+	for _, fallback := range config.FallbackRepos {
+		if !errors.Is(err, ErrNoAsset) {
+			break
+		}
+		config.logf("No asset in %s for version %s, trying %s", config.GithubRepo, version, fallback)
+		selected, err = resolveFallbackAsset(ctx, client, config, fallback, version)
+	}
+	return selected, err
+}
+
+// resolveFallbackAsset selects the asset to install from the release of version in another repo
+func resolveFallbackAsset(ctx context.Context, client *github.Client, config Config, repoPath, version string) (*selectedAsset, error) {
+	owner, repo, ok := strings.Cut(repoPath, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid fallback repo %q, should be 'owner/repo'", repoPath)
+	}
+
+	release, err := getReleaseByVersion(ctx, client, owner, repo, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoAsset, err)
+	}
+	if config.excludesPrerelease(release) {
+		return nil, fmt.Errorf("%w: release %s in %s is a pre-release", ErrNoAsset, release.GetTagName(), repoPath)
+	}
+
+	return resolveAsset(config, release, version)
+}
+
+// executableExt returns the file extension executables carry on the running OS
+func executableExt() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// normalizeExecutablePath appends the OS executable extension when the path lacks one,
+// since os.Args[0] on Windows may omit it when launched as "app" instead of "app.exe"
+func normalizeExecutablePath(path string) string {
+	ext := executableExt()
+	if ext != "" && !strings.EqualFold(filepath.Ext(path), ext) {
+		return path + ext
+	}
+	return path
+}
+
+// findAsset returns the release asset built for the running platform and architecture.
+// Platform and architecture must appear as whole tokens delimited by "-", "_" or ".",
+// and assets naming the architecture more specifically are preferred. On macOS a
+// universal asset is used when there is none for the architecture itself. Among equally
+// specific assets, those matching config.AssetPreference win, then the shortest name
+// and finally the alphabetically first, so that the choice never depends on asset order.
+func findAsset(config Config, assets []*github.ReleaseAsset) (*github.ReleaseAsset, error) {
+	return findAssetFor(config, assets, runtime.GOOS, runtime.GOARCH, goarm())
+}
+
+// findAssetFor is findAsset for the given platform, architecture and ARM version
+func findAssetFor(config Config, assets []*github.ReleaseAsset, platform, arch, armVersion string) (*github.ReleaseAsset, error) {
+	var preference *regexp.Regexp
+	if config.AssetPreference != "" {
+		var err error
+		if preference, err = regexp.Compile(config.AssetPreference); err != nil {
+			return nil, fmt.Errorf("invalid asset preference: %w", err)
+		}
+	}
+
+	platforms := platformAliases(platform)
+	arches := archAliases(arch, armVersion)
+	if platform == "darwin" {
+		arches = append(arches, universalArches...)
+	}
+	var ext string
+	if platform == "windows" {
+		ext = ".exe"
+	}
+
+	type candidate struct {
+		asset     *github.ReleaseAsset
+		rank      int
+		preferred bool
+	}
+
+	var candidates []candidate
+	for _, asset := range assets {
+		if asset.BrowserDownloadURL == nil || asset.Name == nil {
+			continue
+		}
+
+		name := strings.ToLower(*asset.Name)
+
+		// Skip sidecar files such as checksums, and anything else when the OS expects an extension
+		if isSidecar(name) || (ext != "" && !strings.HasSuffix(decompressedName(name), ext)) {
+			continue
+		}
+
+		tokens := assetTokens(name)
+		if indexOfAny(tokens, platforms) < 0 {
+			continue
+		}
+
+		if rank := indexOfAny(tokens, arches); rank >= 0 {
+			preferred := preference != nil && preference.MatchString(*asset.Name)
+			candidates = append(candidates, candidate{asset, rank, preferred})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w for %s/%s", ErrNoAsset, platform, arch)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.rank != b.rank:
+			return a.rank < b.rank
+		case a.preferred != b.preferred:
+			return a.preferred
+		case len(a.asset.GetName()) != len(b.asset.GetName()):
+			return len(a.asset.GetName()) < len(b.asset.GetName())
+		}
+		return a.asset.GetName() < b.asset.GetName()
+	})
+
+	best := candidates[0]
+	if len(candidates) > 1 && candidates[1].rank == best.rank && candidates[1].preferred == best.preferred {
+		var names []string
+		for _, c := range candidates {
+			if c.rank == best.rank && c.preferred == best.preferred {
+				names = append(names, c.asset.GetName())
+			}
+		}
+		config.logf("Multiple assets match %s/%s (%s), using %s", platform, arch, strings.Join(names, ", "), best.asset.GetName())
+	}
+
+	return best.asset, nil
+}
+
+// sidecarExts are extensions of release assets that accompany a binary rather than being one
+var sidecarExts = []string{".sha256", ".sha512", ".b2", ".blake2b", ".checksum", ".sig", ".asc", ".pem", ".json", ".txt"}
+
+// isSidecar reports whether name looks like a checksum, signature or metadata asset
+func isSidecar(name string) bool {
+	for _, ext := range sidecarExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// assetTokens splits an asset name into the words delimited by "-", "_" and ".", in order
+func assetTokens(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+}
+
+// indexOfAny returns the index of the first candidate present in tokens, or -1. A candidate
+// made of several words, such as "x86_64", must appear as consecutive tokens.
+func indexOfAny(tokens []string, candidates []string) int {
+	for i, candidate := range candidates {
+		words := assetTokens(candidate)
+		for start := 0; start+len(words) <= len(tokens); start++ {
+			if slices.Equal(tokens[start:start+len(words)], words) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// platformAliases returns the names an asset may use for the given GOOS
+func platformAliases(goos string) []string {
+	if goos == "darwin" {
+		return []string{"darwin", "macos"}
+	}
+	return []string{goos}
+}
+
+// archAliases returns the names an asset may use for the given GOARCH and ARM version,
+// most specific first. "x86" is not accepted for 386, as it is also part of "x86_64".
+func archAliases(goarch, armVersion string) []string {
+	switch goarch {
+	case "amd64":
+		return []string{"amd64", "x86_64", "x64"}
+	case "arm64":
+		return []string{"arm64", "aarch64"}
+	case "386":
+		return []string{"386", "i386"}
+	case "arm":
+		if armVersion != "" {
+			return []string{"armv" + armVersion, "arm"}
+		}
+		return []string{"arm"}
+	}
+	return []string{goarch}
+}
+
+// goarm returns the ARM version the running binary was built for, such as "7"
+func goarm() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "GOARM" {
+			// Newer toolchains may append a float mode, e.g. "7,softfloat"
+			return strings.SplitN(setting.Value, ",", 2)[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// releaseAssets returns downloadable release assets with the given names
+func releaseAssets(names ...string) []*github.ReleaseAsset {
+	var assets []*github.ReleaseAsset
+	for _, name := range names {
+		assets = append(assets, &github.ReleaseAsset{
+			Name:               github.String(name),
+			BrowserDownloadURL: github.String("https://example.com/" + name),
+		})
+	}
+	return assets
+}
+
+func TestFindAssetFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		arch       string
+		armVersion string
+		assets     []string
+		want       string
+	}{
+		{
+			name:   "amd64 matches x86_64",
+			arch:   "amd64",
+			assets: []string{"app-linux-arm64", "app-linux-x86_64"},
+			want:   "app-linux-x86_64",
+		},
+		{
+			name:   "amd64 prefers amd64 over x86_64",
+			arch:   "amd64",
+			assets: []string{"app-linux-x86_64", "app-linux-amd64"},
+			want:   "app-linux-amd64",
+		},
+		{
+			name:   "386 does not match x86_64",
+			arch:   "386",
+			assets: []string{"app-linux-x86_64"},
+		},
+		{
+			name:   "386 matches i386",
+			arch:   "386",
+			assets: []string{"app-linux-x86_64", "app-linux-i386"},
+			want:   "app-linux-i386",
+		},
+		{
+			name:   "arm64 matches aarch64",
+			arch:   "arm64",
+			assets: []string{"app-linux-armv7", "app-linux-arm", "app-linux-aarch64"},
+			want:   "app-linux-aarch64",
+		},
+		{
+			name:   "arm64 does not match arm",
+			arch:   "arm64",
+			assets: []string{"app-linux-arm", "app-linux-armv7"},
+		},
+		{
+			name:       "armv7 prefers armv7 over arm",
+			arch:       "arm",
+			armVersion: "7",
+			assets:     []string{"app-linux-arm", "app-linux-arm64", "app-linux-armv7"},
+			want:       "app-linux-armv7",
+		},
+		{
+			name:       "armv7 falls back to arm",
+			arch:       "arm",
+			armVersion: "7",
+			assets:     []string{"app-linux-arm64", "app-linux-armv6", "app-linux-arm"},
+			want:       "app-linux-arm",
+		},
+		{
+			name:       "armv6 does not match armv7",
+			arch:       "arm",
+			armVersion: "6",
+			assets:     []string{"app-linux-armv7", "app-linux-arm64"},
+		},
+		{
+			name:   "arm without a version matches arm only",
+			arch:   "arm",
+			assets: []string{"app-linux-arm64", "app-linux-armv7", "app-linux-arm"},
+			want:   "app-linux-arm",
+		},
+		{
+			name:   "sidecars are skipped",
+			arch:   "amd64",
+			assets: []string{"app-linux-amd64.sha256", "app-linux-amd64"},
+			want:   "app-linux-amd64",
+		},
+	}
+
+	config := Config{Logger: log.New(io.Discard, "", 0)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset, err := findAssetFor(config, releaseAssets(tt.assets...), "linux", tt.arch, tt.armVersion)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNoAsset) {
+					t.Fatalf("expected ErrNoAsset, got %v, %v", asset.GetName(), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if asset.GetName() != tt.want {
+				t.Errorf("selected %s, want %s", asset.GetName(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// updater/audit.go
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAuditTampered is returned by ReadAuditLog when an entry was changed, removed or
+// inserted, which breaks the hash chain
+var ErrAuditTampered = errors.New("audit log has been tampered with")
+
+// Outcomes recorded in the audit log
+const (
+	AuditInstalled = "installed"
+	AuditFailed    = "failed"
+)
+
+// maxAuditLine bounds the size of a single audit log entry when reading
+const maxAuditLine = 1 << 20
+
+// auditMu serializes appends, which must each chain to the entry before
+var auditMu sync.Mutex
+
+// AuditEntry records one attempt to apply a downloaded update. Each entry includes the
+// hash of the one before it, so that editing or removing an entry breaks the chain of
+// every entry after it.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Name is the target the update was applied to, empty for the application itself
+	Name string `json:"name,omitempty"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Source is the URL or bundle the update came from
+	Source    string `json:"source"`
+	Asset     string `json:"asset"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	// Verified lists the checks the update passed, such as "sha256" or "manifest signature"
+	Verified []string `json:"verified,omitempty"`
+	Outcome  string   `json:"outcome"`
+	Error    string   `json:"error,omitempty"`
+	PrevHash string   `json:"prev_hash"`
+	Hash     string   `json:"hash"`
+}
+
+// audit appends the outcome of installing version to AuditLog. Deferred updates are not
+// recorded until they are applied. digest is the SHA-256 of the installed file, recorded
+// when selected carries no checksum.
+func (c Config) audit(version string, selected *selectedAsset, digest string, result *Result, err error) {
+	if c.AuditLog == "" || (err == nil && (result == nil || !result.Updated)) {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:      c.now().UTC(),
+		Name:      c.Name,
+		From:      c.CurrentVersion,
+		To:        version,
+		Source:    selected.source,
+		Asset:     selected.name,
+		Algorithm: selected.algorithm,
+		Checksum:  selected.checksum,
+		Outcome:   AuditInstalled,
+	}
+	if entry.Checksum == "" && digest != "" {
+		entry.Algorithm, entry.Checksum = SHA256, digest
+	}
+	if entry.Source == "" && selected.asset != nil {
+		entry.Source = selected.asset.GetBrowserDownloadURL()
+	}
+	if err != nil {
+		entry.Outcome, entry.Error = AuditFailed, err.Error()
+	} else {
+		entry.Verified = c.verifications(selected)
+	}
+
+	if err := appendAudit(c.AuditLog, entry); err != nil {
+		c.logf("Failed to write audit log: %v", err)
+	}
+}
+
+// verifications lists the checks an installed update passed
+func (c Config) verifications(selected *selectedAsset) []string {
+	var passed []string
+	if c.SourceArchive == "" {
+		if c.TUF != nil {
+			passed = append(passed, "tuf metadata")
+		}
+		if c.ManifestPublicKey != nil {
+			passed = append(passed, "manifest signature")
+		}
+		if selected.checksum != "" {
+			passed = append(passed, selected.algorithm)
+		}
+		if selected.size > 0 {
+			passed = append(passed, "size")
+		}
+		if len(c.ChecksumSources) > 0 {
+			passed = append(passed, "checksum sources")
+		}
+		if selected.universal {
+			passed = append(passed, "universal binary")
+		}
+		if len(c.VersionProbe) > 0 {
+			passed = append(passed, "version probe")
+		}
+	}
+	if len(c.Verifiers) > 0 {
+		passed = append(passed, "verifiers")
+	}
+	return passed
+}
+
+// appendAudit chains entry to the last entry of the log at path and appends it
+func appendAudit(path string, entry AuditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	prev, err := lastAuditHash(path)
+	if err != nil {
+		return err
+	}
+	entry.PrevHash = prev
+	if entry.Hash, err = auditHash(entry); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", noSpace(err))
+	}
+	return file.Sync()
+}
+
+// lastAuditHash returns the hash of the last entry in the log at path, empty when there is none
+func lastAuditHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return "", nil
+	}
+	var last AuditEntry
+	if err := json.Unmarshal(data[bytes.LastIndexByte(data, '\n')+1:], &last); err != nil {
+		return "", fmt.Errorf("failed to parse last audit log entry: %w", err)
+	}
+	return last.Hash, nil
+}
+
+// auditHash returns the hash of entry, covering every field but the hash itself
+func auditHash(entry AuditEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// ReadAuditLog returns the entries of the audit log at path, oldest first, and verifies
+// that they form an unbroken hash chain. When an entry does not match, the entries read so
+// far are returned together with ErrAuditTampered. Entries removed from the end cannot be
+// detected this way, so the hash of the last entry should be kept elsewhere as well.
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	prev := ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxAuditLine)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("%w: entry %d cannot be parsed: %v", ErrAuditTampered, len(entries)+1, err)
+		}
+		hash, err := auditHash(entry)
+		if err != nil {
+			return entries, err
+		}
+		if entry.PrevHash != prev || entry.Hash != hash {
+			return entries, fmt.Errorf("%w: entry %d does not match its hash chain", ErrAuditTampered, len(entries)+1)
+		}
+
+		entries = append(entries, entry)
+		prev = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,92 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadAuditLogDetectsTampering(t *testing.T) {
+	// lines are those of an untampered log with three entries
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i, version := range []string{"1.1.0", "1.2.0", "1.3.0"} {
+		entry := AuditEntry{Time: time.Date(2026, 1, i+1, 0, 0, 0, 0, time.UTC), To: version, Outcome: AuditInstalled}
+		if err := appendAudit(path, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	// rehash returns a line with its entry changed by edit and its own hash updated to
+	// match, as an attacker covering their tracks would
+	rehash := func(line string, edit func(*AuditEntry)) string {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		edit(&entry)
+		hash, err := auditHash(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Hash = hash
+		out, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		// valid is how many entries are read before the chain breaks, all when not tampered
+		valid    int
+		tampered bool
+	}{
+		{name: "untouched", lines: lines, valid: 3},
+		{name: "edited", lines: []string{lines[0], strings.Replace(lines[1], "1.2.0", "6.6.6", 1), lines[2]}, valid: 1, tampered: true},
+		{name: "edited and rehashed", lines: []string{lines[0], rehash(lines[1], func(e *AuditEntry) { e.To = "6.6.6" }), lines[2]}, valid: 2, tampered: true},
+		{name: "deleted", lines: []string{lines[0], lines[2]}, valid: 1, tampered: true},
+		{name: "inserted", lines: []string{lines[0], lines[1], rehash(lines[1], func(e *AuditEntry) { e.PrevHash, e.To = mustHashOf(t, lines[1]), "6.6.6" }), lines[2]}, valid: 3, tampered: true},
+		{name: "unparsable", lines: []string{lines[0], "{not json", lines[2]}, valid: 1, tampered: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := ReadAuditLog(path)
+			if tt.tampered != errors.Is(err, ErrAuditTampered) {
+				t.Fatalf("ReadAuditLog() error = %v, want tampered %v", err, tt.tampered)
+			}
+			if !tt.tampered && err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.valid {
+				t.Errorf("ReadAuditLog() returned %d entries, want the %d before the break", len(entries), tt.valid)
+			}
+		})
+	}
+}
+
+// mustHashOf returns the hash recorded in an audit log line
+func mustHashOf(t *testing.T, line string) string {
+	t.Helper()
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry.Hash
+}
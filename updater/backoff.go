@@ -0,0 +1,96 @@
+// updater/backoff.go
+package updater
+
+import (
+	"errors"
+	"time"
+)
+
+// maxFailureBackoff caps how long a version that keeps failing to install is left alone
+const maxFailureBackoff = 24 * time.Hour
+
+// FailedUpdate is a version that failed to install and is not retried before RetryAt
+type FailedUpdate struct {
+	Version string    `json:"version"`
+	Count   int       `json:"count"`
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// failureRetryAt returns when version may be attempted again after failing to install,
+// or the zero time when it may be attempted now. Other versions are never held back, so
+// a newer release is installed as soon as it appears.
+func (c Config) failureRetryAt(version string) time.Time {
+	if c.FailureBackoff <= 0 || c.ExecutablePath == "" {
+		return time.Time{}
+	}
+
+	state, err := LoadState(c.ExecutablePath)
+	if err != nil || state.Failure == nil || compareVersions(state.Failure.Version, version) != 0 {
+		return time.Time{}
+	}
+	if !c.now().Before(state.Failure.RetryAt) {
+		return time.Time{}
+	}
+	return state.Failure.RetryAt
+}
+
+// recordFailure holds version back for FailureBackoff after its first failure, doubling
+// the wait with each further failure up to maxFailureBackoff
+func (c Config) recordFailure(version string) {
+	if c.FailureBackoff <= 0 || c.ExecutablePath == "" {
+		return
+	}
+
+	var failure FailedUpdate
+	err := updateState(c.ExecutablePath, func(s *State) {
+		if s.Failure == nil || compareVersions(s.Failure.Version, version) != 0 {
+			s.Failure = &FailedUpdate{Version: version}
+		}
+
+		backoff := c.FailureBackoff
+		for i := 0; i < s.Failure.Count && backoff < maxFailureBackoff; i++ {
+			backoff *= 2
+		}
+		s.Failure.Count++
+		s.Failure.RetryAt = c.now().Add(min(backoff, maxFailureBackoff))
+		failure = *s.Failure
+	})
+	if err != nil {
+		c.logf("Failed to record failed update: %v", err)
+		return
+	}
+	c.logf("Update to %s failed (attempt %d), not retrying it before %s", version, failure.Count, failure.RetryAt.Format(time.RFC3339))
+}
+
+// installFailure marks an error of verifying or applying a downloaded update. Only these
+// are backed off; network errors, stalled or truncated downloads and a full disk are
+// transient and retried on the next check.
+type installFailure struct {
+	err error
+}
+
+func (e *installFailure) Error() string {
+	return e.err.Error()
+}
+
+func (e *installFailure) Unwrap() error {
+	return e.err
+}
+
+// failed marks err, if any, as a failure to verify or apply an update
+func failed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &installFailure{err}
+}
+
+// countsAsFailure reports whether err means the update itself failed verification or could
+// not be applied, rather than failing for a reason that may be gone on the next check
+func countsAsFailure(err error) bool {
+	var failure *installFailure
+	if !errors.As(err, &failure) {
+		return false
+	}
+	return !errors.Is(err, ErrSizeMismatch) && !errors.Is(err, ErrStalled) && !errors.Is(err, ErrInsufficientSpace)
+}
@@ -0,0 +1,166 @@
+// updater/backup.go
+package updater
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupInfo describes a backup of the executable
+type BackupInfo struct {
+	// Index is 1 for the most recent backup, as accepted by Rollback
+	Index int
+	// Version is empty for backups made before versions were recorded
+	Version string
+	// Created is when the backup was made
+	Created time.Time
+	SHA256  string
+	Path    string
+}
+
+// backupPath returns the path of the backup with the given index, 1 being the most recent
+func backupPath(executablePath string, index int) string {
+	return executablePath + ".bak." + strconv.Itoa(index)
+}
+
+// backupVersionPath returns the path of the file recording the version of a backup
+func backupVersionPath(executablePath string, index int) string {
+	return backupPath(executablePath, index) + ".version"
+}
+
+// pushBackup stores the current executable as backup 1 and shifts existing backups up by
+// one. Nothing is pruned, so that popBackup can undo it when the update is not applied.
+func pushBackup(executablePath, version string) error {
+	// Copy first, so that a failed copy leaves the existing backups untouched
+	tmpPath := executablePath + ".bak.tmp"
+	if err := copyFile(executablePath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	last := 0
+	for backupExists(executablePath, last+1) {
+		last++
+	}
+	for i := last; i >= 1; i-- {
+		if err := os.Rename(backupPath(executablePath, i), backupPath(executablePath, i+1)); err != nil {
+			os.Remove(tmpPath)
+			shiftBackupsDown(executablePath, i+2, last+1)
+			return fmt.Errorf("failed to rotate backup %d: %w", i, err)
+		}
+		os.Rename(backupVersionPath(executablePath, i), backupVersionPath(executablePath, i+1))
+	}
+
+	if err := os.Rename(tmpPath, backupPath(executablePath, 1)); err != nil {
+		os.Remove(tmpPath)
+		shiftBackupsDown(executablePath, 2, last+1)
+		return err
+	}
+	return os.WriteFile(backupVersionPath(executablePath, 1), []byte(version), 0644)
+}
+
+// popBackup removes backup 1 and shifts the remaining backups down by one, undoing pushBackup
+func popBackup(executablePath string) {
+	os.Remove(backupPath(executablePath, 1))
+	os.Remove(backupVersionPath(executablePath, 1))
+
+	last := 1
+	for backupExists(executablePath, last+1) {
+		last++
+	}
+	shiftBackupsDown(executablePath, 2, last)
+}
+
+// shiftBackupsDown moves backups from through to one index down
+func shiftBackupsDown(executablePath string, from, to int) {
+	for i := from; i <= to; i++ {
+		os.Rename(backupPath(executablePath, i), backupPath(executablePath, i-1))
+		os.Rename(backupVersionPath(executablePath, i), backupVersionPath(executablePath, i-1))
+	}
+}
+
+// backupExists reports whether the backup with the given index exists
+func backupExists(executablePath string, index int) bool {
+	_, err := os.Stat(backupPath(executablePath, index))
+	return err == nil
+}
+
+// pruneBackups removes all backups with an index greater than keep
+func pruneBackups(executablePath string, keep int) {
+	if keep < 1 {
+		keep = 1
+	}
+
+	for i := keep + 1; backupExists(executablePath, i); i++ {
+		os.Remove(backupPath(executablePath, i))
+		os.Remove(backupVersionPath(executablePath, i))
+	}
+}
+
+// ListBackups returns the backups of the executable, most recent first. Any of them can be
+// restored with Rollback or RollbackToVersion.
+func ListBackups(executablePath string) ([]BackupInfo, error) {
+	var backups []BackupInfo
+	for i := 1; ; i++ {
+		path := backupPath(executablePath, i)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return backups, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup %d: %w", i, err)
+		}
+
+		checksum, err := hashFile(path, SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup %d: %w", i, err)
+		}
+
+		var version string
+		if data, err := os.ReadFile(backupVersionPath(executablePath, i)); err == nil {
+			version = strings.TrimSpace(string(data))
+		}
+
+		backups = append(backups, BackupInfo{Index: i, Version: version, Created: info.ModTime(), SHA256: checksum, Path: path})
+	}
+}
+
+// Rollback restores the executable from the backup with the given index, 1 being the most recent
+func Rollback(executablePath string, index int) error {
+	src := backupPath(executablePath, index)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("backup %d not found: %w", index, err)
+	}
+
+	// The copy keeps the permissions the executable had when it was backed up
+	tmpPath := executablePath + ".rollback"
+	if err := copyFile(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	// A symlinked executable keeps its link, the file it points to is restored
+	if err := os.Rename(tmpPath, resolveLink(executablePath)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	return nil
+}
+
+// RollbackToVersion restores the executable from the most recent backup of the given version
+func RollbackToVersion(executablePath, version string) error {
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupPath(executablePath, i)); err != nil {
+			return fmt.Errorf("no backup found for version %s", version)
+		}
+
+		data, err := os.ReadFile(backupVersionPath(executablePath, i))
+		if err == nil && strings.TrimSpace(string(data)) == version {
+			return Rollback(executablePath, i)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPushBackupRotates(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "app")
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		writeFile(t, exe, "binary "+version)
+		if err := pushBackup(exe, version); err != nil {
+			t.Fatalf("pushBackup(%s): %v", version, err)
+		}
+	}
+
+	for index, version := range map[int]string{1: "1.2.0", 2: "1.1.0", 3: "1.0.0"} {
+		assertFile(t, backupPath(exe, index), "binary "+version)
+		assertFile(t, backupVersionPath(exe, index), version)
+	}
+	assertMissing(t, backupPath(exe, 4))
+	assertMissing(t, exe+".bak.tmp")
+
+	pruneBackups(exe, 2)
+	assertFile(t, backupPath(exe, 2), "binary 1.1.0")
+	assertMissing(t, backupPath(exe, 3))
+	assertMissing(t, backupVersionPath(exe, 3))
+}
+
+func TestPopBackupUndoesPush(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "app")
+
+	writeFile(t, exe, "binary 1.0.0")
+	if err := pushBackup(exe, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, exe, "binary 1.1.0")
+	if err := pushBackup(exe, "1.1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	popBackup(exe)
+
+	assertFile(t, backupPath(exe, 1), "binary 1.0.0")
+	assertFile(t, backupVersionPath(exe, 1), "1.0.0")
+	assertMissing(t, backupPath(exe, 2))
+	assertMissing(t, backupVersionPath(exe, 2))
+	assertFile(t, exe, "binary 1.1.0")
+}
+
+func TestRollbackRestores(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "app")
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		writeFile(t, exe, "binary "+version)
+		if err := pushBackup(exe, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, exe, "binary 1.2.0")
+
+	if err := RollbackToVersion(exe, "1.0.0"); err != nil {
+		t.Fatalf("RollbackToVersion: %v", err)
+	}
+	assertFile(t, exe, "binary 1.0.0")
+	assertMissing(t, exe+".rollback")
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("restored executable has mode %v, want it executable", info.Mode().Perm())
+	}
+
+	// Backups stay in place, so a rollback can be repeated
+	if err := Rollback(exe, 1); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	assertFile(t, exe, "binary 1.1.0")
+	assertFile(t, backupPath(exe, 2), "binary 1.0.0")
+
+	if err := RollbackToVersion(exe, "0.9.0"); err == nil {
+		t.Error("RollbackToVersion of a version without a backup succeeded")
+	}
+	if err := Rollback(exe, 3); err == nil {
+		t.Error("Rollback to a missing backup succeeded")
+	}
+}
@@ -0,0 +1,76 @@
+// updater/budget.go
+package updater
+
+import (
+	"sort"
+	"time"
+)
+
+// maxInstallHistory bounds how many install times the state keeps for the update budget
+const maxInstallHistory = 100
+
+// defaultUpdateBudgetWindow is the window MaxUpdatesPerWindow applies to by default
+const defaultUpdateBudgetWindow = 24 * time.Hour
+
+// updateBudgetWindow returns the window MaxUpdatesPerWindow applies to
+func (c Config) updateBudgetWindow() time.Duration {
+	if c.UpdateBudgetWindow > 0 {
+		return c.UpdateBudgetWindow
+	}
+	return defaultUpdateBudgetWindow
+}
+
+// nextBudgetSlot returns when the next update may be installed once MaxUpdatesPerWindow
+// updates were installed within the last UpdateBudgetWindow, or the zero time when the
+// budget allows an update now. The window slides, so a slot frees up as soon as the
+// oldest counted install falls out of it.
+func (c Config) nextBudgetSlot() time.Time {
+	if c.MaxUpdatesPerWindow <= 0 {
+		return time.Time{}
+	}
+
+	window := c.updateBudgetWindow()
+	state, err := LoadState(c.ExecutablePath)
+	if err != nil {
+		c.logf("Failed to read install history, not enforcing the update budget: %v", err)
+		return time.Time{}
+	}
+
+	now := c.now()
+	var recent []time.Time
+	for _, installed := range state.Installs {
+		if now.Sub(installed) < window {
+			recent = append(recent, installed)
+		}
+	}
+	if len(recent) < c.MaxUpdatesPerWindow {
+		return time.Time{}
+	}
+
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Before(recent[j]) })
+	return recent[len(recent)-c.MaxUpdatesPerWindow].Add(window)
+}
+
+// cooldownEnd returns when RestartCooldown has passed since the application last restarted,
+// or the zero time when an update may be installed now. The last start and the last
+// install both count as a restart, since every install restarts the application.
+func (c Config) cooldownEnd() time.Time {
+	if c.RestartCooldown <= 0 {
+		return time.Time{}
+	}
+
+	state, err := LoadState(c.ExecutablePath)
+	if err != nil {
+		c.logf("Failed to read restart history, not enforcing the restart cooldown: %v", err)
+		return time.Time{}
+	}
+
+	last := state.StartedAt
+	if n := len(state.Installs); n > 0 && state.Installs[n-1].After(last) {
+		last = state.Installs[n-1]
+	}
+	if end := last.Add(c.RestartCooldown); c.now().Before(end) {
+		return end
+	}
+	return time.Time{}
+}
@@ -0,0 +1,65 @@
+package updater
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestartCooldownDefersSecondInstall(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive bool
+	}{
+		{name: "executable"},
+		{name: "source archive", archive: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPipelineFixture(t)
+
+			config := f.config
+			config.RestartCooldown = time.Hour
+			if tt.archive {
+				config.SourceArchive = Tarball
+				config.TargetDir = filepath.Join(filepath.Dir(f.exe), "site")
+				f.tarball = tarGz(t, archiveEntry{name: "owner-app-1a2b3c/index.html", body: "site 1.1.0"})
+			}
+
+			result, err := CheckAndUpdate(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Updated {
+				t.Fatalf("first CheckAndUpdate() = %+v, want version 1.1.0 installed", result)
+			}
+
+			// The next release is out before the cooldown after installing 1.1.0 has passed
+			config.CurrentVersion = "1.1.0"
+			f.tag = "v1.2.0"
+			f.tarball = tarGz(t, archiveEntry{name: "owner-app-4d5e6f/index.html", body: "site 1.2.0"})
+
+			result, err = CheckAndUpdate(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Updated || !result.Deferred || result.Version != "1.2.0" {
+				t.Fatalf("second CheckAndUpdate() = %+v, want version 1.2.0 deferred", result)
+			}
+			if wait := time.Until(result.ApplyAt); wait <= 59*time.Minute || wait > time.Hour {
+				t.Errorf("update deferred for %s, want the rest of the hour long cooldown", wait)
+			}
+
+			if tt.archive {
+				assertFile(t, filepath.Join(config.TargetDir, "index.html"), "site 1.1.0")
+				if got := f.downloads["tarball"]; got != 1 {
+					t.Errorf("tarball downloaded %d times, want only the first release", got)
+				}
+			} else {
+				assertFile(t, f.exe, "binary 1.1.0")
+				assertFile(t, config.stagedPath("1.2.0"), "binary 1.1.0")
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// updater/bundle.go
+package updater
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BundleExt is the extension of offline update bundles. A bundle is an uncompressed tar
+// holding manifest.json, optionally manifest.json.sig, and the assets the manifest lists.
+const BundleExt = ".otapkg"
+
+// ApplyBundle installs the update contained in the bundle at path. It gives the same
+// guarantees as a network update: the manifest signature is verified when a public key is
+// configured, the host must meet the asset's requirements and the asset must match its checksum.
+func ApplyBundle(config Config, path string) (*Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	manifestData, signature, err := readBundleMetadata(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ManifestPublicKey != nil {
+		if signature == nil {
+			return nil, fmt.Errorf("%w: bundle has no %s", ErrUnsignedRelease, manifestSignatureName)
+		}
+		if err := verifySignature(config.ManifestPublicKey, manifestData, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Version == "" {
+		return nil, errors.New("bundle manifest has no version")
+	}
+	version := strings.TrimPrefix(manifest.Version, "v")
+
+	switch cmp := compareVersions(version, config.CurrentVersion); {
+	case cmp == 0:
+		return &Result{Version: config.CurrentVersion}, nil
+	case cmp < 0 && !config.AllowDowngrade:
+		return nil, fmt.Errorf("bundle version %s is older than current version %s and downgrades are not allowed", version, config.CurrentVersion)
+	}
+
+	selected, err := selectFromManifest(config, &manifest, version)
+	if err != nil {
+		return nil, err
+	}
+	if selected.checksum == "" {
+		return nil, fmt.Errorf("bundle manifest lists no checksum for %s", selected.name)
+	}
+	if entry := manifest.find(runtime.GOOS, runtime.GOARCH); entry.PreInstall != nil || entry.PostInstall != nil {
+		return nil, fmt.Errorf("%w: install scripts are not supported in bundles", ErrInstallScript)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tempPath, mode, err := extractBundleAsset(config, file, selected.name)
+	if err != nil {
+		return nil, err
+	}
+	config.FileMode = config.fileMode() | config.setuidBits(selected.name, mode)
+
+	selected.source = path
+	result, err := installBundleAsset(config, path, version, selected, tempPath)
+	config.audit(version, selected, "", result, err)
+	return result, err
+}
+
+// installBundleAsset verifies the executable extracted from the bundle at path to tempPath
+// and installs it
+func installBundleAsset(config Config, path, version string, selected *selectedAsset, tempPath string) (*Result, error) {
+	if err := verifyDownload(config, tempPath, selected, ""); err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to verify bundle: %w", err)
+	}
+
+	if err := checkUniversal(tempPath, selected); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	if err := probeVersion(config, tempPath, version); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	if err := runVerifiers(config, tempPath, version, selected); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	config.ExecutablePath = normalizeExecutablePath(config.ExecutablePath)
+	config.logf("Installing version %s for %s/%s from %s", version, runtime.GOOS, runtime.GOARCH, filepath.Base(path))
+
+	if err := applyUpdate(config, tempPath, version); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	if err := recordInstall(config, version); err != nil {
+		config.logf("Failed to record install: %v", err)
+	}
+	if err := writeReadyMarker(config, version); err != nil {
+		return nil, err
+	}
+
+	return &Result{Updated: true, Version: version, Urgency: selected.urgency}, nil
+}
+
+// readBundleMetadata returns the manifest and, if present, its signature from a bundle
+func readBundleMetadata(r io.Reader) ([]byte, []byte, error) {
+	var manifest, signature []byte
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		switch header.Name {
+		case manifestAssetName:
+			manifest, err = io.ReadAll(io.LimitReader(tr, maxManifestSize))
+		case manifestSignatureName:
+			signature, err = io.ReadAll(io.LimitReader(tr, maxChecksumFileSize))
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from bundle: %w", header.Name, err)
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("bundle has no %s", manifestAssetName)
+	}
+	return manifest, signature, nil
+}
+
+// extractBundleAsset copies the named asset out of a bundle into a temporary file and
+// returns its path together with the mode recorded in the bundle
+func extractBundleAsset(config Config, r io.Reader, name string) (string, os.FileMode, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return "", 0, fmt.Errorf("%w: bundle does not contain %s", ErrNoAsset, name)
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Name != name || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		tempFile, err := os.CreateTemp(config.stagingDir(), "update_*.bin")
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to create temp file: %w", err)
+		}
+
+		_, err = io.Copy(tempFile, tr)
+		tempFile.Close()
+		if err != nil {
+			os.Remove(tempFile.Name())
+			return "", 0, fmt.Errorf("failed to extract %s from bundle: %w", name, err)
+		}
+		return tempFile.Name(), header.FileInfo().Mode(), nil
+	}
+}
+
+// CreateBundle packs the manifest at manifestPath, its signature if present and every
+// asset it lists, all read from the manifest's directory, into "<version>.otapkg" in
+// that directory and returns its path
+func CreateBundle(manifestPath string) (string, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Version == "" {
+		return "", errors.New("manifest has no version")
+	}
+
+	dir := filepath.Dir(manifestPath)
+	out := filepath.Join(dir, manifest.Version+BundleExt)
+	files := [][2]string{{manifestAssetName, manifestPath}}
+	if _, err := os.Stat(manifestPath + ".sig"); err == nil {
+		files = append(files, [2]string{manifestSignatureName, manifestPath + ".sig"})
+	}
+	for _, asset := range manifest.Assets {
+		files = append(files, [2]string{asset.Name, filepath.Join(dir, asset.Name)})
+	}
+
+	bundle, err := os.Create(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	tw := tar.NewWriter(bundle)
+	for _, f := range files {
+		if err = addBundleFile(tw, f[0], f[1]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := bundle.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out)
+		return "", fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return out, nil
+}
+
+// addBundleFile adds the file at src to a bundle under name
+func addBundleFile(tw *tar.Writer, name, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,323 @@
+// updater/checksum.go
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-github/v40/github"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Supported checksum algorithms
+const (
+	SHA256  = "sha256"
+	SHA512  = "sha512"
+	BLAKE2b = "blake2b"
+)
+
+// ErrSizeMismatch is returned when a download is not as large as its release declares or
+// its Content-Length announced, catching truncated downloads even without a checksum
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// maxChecksumFileSize bounds how much of a checksum asset is read
+const maxChecksumFileSize = 64 << 10
+
+// checksumExts maps checksum asset extensions to the algorithm they hold. An empty
+// algorithm means it is inferred from the length of the digest.
+var checksumExts = []struct{ ext, algorithm string }{
+	{".sha256", SHA256},
+	{".sha512", SHA512},
+	{".b2", BLAKE2b},
+	{".blake2b", BLAKE2b},
+	{".checksum", ""},
+}
+
+// newHash returns a hash for the given algorithm
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE2b:
+		return blake2b.New512(nil)
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+}
+
+// hashFile returns the hex digest of the file at path
+func hashFile(path, algorithm string) (string, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// algorithmForDigest infers the algorithm of a hex digest from its length. 128 hex
+// characters fit both SHA-512 and BLAKE2b-512, so a configured preference decides.
+func algorithmForDigest(digest, preferred string) (string, error) {
+	switch len(digest) {
+	case 64:
+		return SHA256, nil
+	case 128:
+		if preferred == BLAKE2b {
+			return BLAKE2b, nil
+		}
+		return SHA512, nil
+	}
+	return "", fmt.Errorf("cannot infer checksum algorithm from a %d character digest", len(digest))
+}
+
+// digestLength returns the length of a hex digest produced by algorithm
+func digestLength(algorithm string) int {
+	if algorithm == SHA256 {
+		return 2 * sha256.Size
+	}
+	return 2 * sha512.Size
+}
+
+// fetchAssetDigest returns the algorithm and digest GitHub computed for an uploaded asset,
+// or empty strings when it has none or it cannot be fetched. go-github does not decode the field, so the asset is
+// requested once more from the API. The digest of a gzipped asset covers the compressed
+// file while downloads are verified decompressed, so it is not used for those.
+func fetchAssetDigest(config Config, asset *github.ReleaseAsset) (string, string, error) {
+	if asset.GetURL() == "" || isGzipAsset(asset.GetName()) {
+		return "", "", nil
+	}
+
+	client := newGithubClient(config)
+	req, err := client.NewRequest(http.MethodGet, asset.GetURL(), nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	var meta struct {
+		Digest string `json:"digest"`
+	}
+	if _, err := client.Do(context.Background(), req, &meta); err != nil {
+		// The digest is a convenience, the other checksum sources still apply without it
+		config.logf("Failed to get the digest of %s: %v", asset.GetName(), err)
+		return "", "", nil
+	}
+
+	prefix, digest, ok := strings.Cut(strings.ToLower(meta.Digest), ":")
+	if !ok {
+		return "", "", nil
+	}
+	switch prefix {
+	case SHA256, SHA512:
+		if _, err := hex.DecodeString(digest); err != nil || len(digest) != digestLength(prefix) {
+			return "", "", fmt.Errorf("malformed %s asset digest %q", prefix, digest)
+		}
+		return prefix, digest, nil
+	}
+	// Algorithms the updater cannot verify leave the other checksum sources to decide
+	return "", "", nil
+}
+
+// fetchSidecarChecksum looks for a checksum asset named after asset, such as
+// "app-linux-amd64.sha256", and returns its algorithm and digest. The preferred
+// algorithm is used when several are published; empty strings mean none was found.
+// For a gzipped asset the checksum is that of the decompressed executable, so it is
+// named after the asset without ".gz".
+func fetchSidecarChecksum(config Config, assets []*github.ReleaseAsset, asset *github.ReleaseAsset) (string, string, error) {
+	var sidecar *github.ReleaseAsset
+	var algorithm string
+	for _, c := range checksumExts {
+		candidate := assetByName(assets, decompressedName(asset.GetName())+c.ext)
+		if candidate == nil {
+			continue
+		}
+		if sidecar == nil || c.algorithm == config.ChecksumAlgorithm {
+			sidecar, algorithm = candidate, c.algorithm
+		}
+	}
+
+	if sidecar == nil {
+		return "", "", nil
+	}
+
+	resp, err := fetchAsset(config, sidecar.GetBrowserDownloadURL())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to download checksum: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumFileSize))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read checksum: %w", err)
+	}
+
+	// Accept both a bare digest and the "<digest>  <file>" format of sha256sum and friends
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("checksum asset %s is empty", sidecar.GetName())
+	}
+	digest := strings.ToLower(fields[0])
+
+	if algorithm == "" {
+		if algorithm, err = algorithmForDigest(digest, config.ChecksumAlgorithm); err != nil {
+			return "", "", err
+		}
+	}
+
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != digestLength(algorithm) {
+		return "", "", fmt.Errorf("checksum asset %s does not hold a %s digest", sidecar.GetName(), algorithm)
+	}
+
+	return algorithm, digest, nil
+}
+
+// manifestChecksum returns the algorithm and digest a manifest entry provides,
+// preferring the configured algorithm when the entry lists several
+func manifestChecksum(entry *ManifestAsset, preferred string) (string, string) {
+	digests := []struct{ algorithm, digest string }{
+		{SHA256, entry.SHA256},
+		{SHA512, entry.SHA512},
+		{BLAKE2b, entry.BLAKE2b},
+	}
+
+	var algorithm, digest string
+	for _, d := range digests {
+		if d.digest == "" {
+			continue
+		}
+		if digest == "" || d.algorithm == preferred {
+			algorithm, digest = d.algorithm, d.digest
+		}
+	}
+	return algorithm, digest
+}
+
+// CurrentBinaryChecksum returns the SHA-256 digest of the running executable. On Linux the
+// running image is read through /proc/self/exe, which keeps pointing at it even after the
+// file on disk has been replaced. Elsewhere the file is hashed again if it changed meanwhile.
+func CurrentBinaryChecksum() (string, error) {
+	if runtime.GOOS == "linux" {
+		if digest, err := hashFile("/proc/self/exe", SHA256); err == nil {
+			return digest, nil
+		}
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		before, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		digest, err := hashFile(path, SHA256)
+		if err != nil {
+			return "", err
+		}
+
+		after, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if os.SameFile(before, after) && before.ModTime().Equal(after.ModTime()) && before.Size() == after.Size() {
+			return digest, nil
+		}
+		if attempt == 2 {
+			return "", fmt.Errorf("executable %s kept changing while it was hashed", path)
+		}
+	}
+}
+
+// isCorrupted reports whether the installed executable differs from the asset of the same
+// version. Without a published checksum there is nothing to compare against.
+func isCorrupted(config Config, selected *selectedAsset) bool {
+	if selected.checksum == "" {
+		return false
+	}
+
+	digest, err := hashFile(config.ExecutablePath, selected.algorithm)
+	if err != nil {
+		config.logf("Failed to verify installed executable: %v", err)
+		return false
+	}
+	if strings.EqualFold(digest, selected.checksum) {
+		return false
+	}
+
+	config.logf("Installed executable does not match the %s checksum of version %s", selected.algorithm, config.CurrentVersion)
+	return true
+}
+
+// verifyDownload checks the file at path against the size and checksum expected for the
+// selected asset. digest is the checksum computed while downloading, or empty to hash the file.
+// Every checksum still to compute is computed in a single read of the file.
+func verifyDownload(config Config, path string, selected *selectedAsset, digest string) error {
+	if selected.size > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != selected.size {
+			return fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, selected.size, info.Size())
+		}
+	}
+	if err := config.fault(faultChecksum); err != nil {
+		return err
+	}
+
+	if selected.checksum == "" {
+		return nil
+	}
+
+	var algorithms []string
+	if digest == "" {
+		algorithms = append(algorithms, selected.algorithm)
+	}
+	for _, extra := range selected.extra {
+		algorithms = append(algorithms, extra.algorithm)
+	}
+
+	var digests []string
+	if len(algorithms) > 0 {
+		var err error
+		if digests, err = config.hashFileAll(path, algorithms...); err != nil {
+			return err
+		}
+	}
+	if digest == "" {
+		digest, digests = digests[0], digests[1:]
+	}
+
+	if !strings.EqualFold(digest, selected.checksum) {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", selected.algorithm, selected.checksum, digest)
+	}
+
+	for i, extra := range selected.extra {
+		if actual := digests[i]; !strings.EqualFold(actual, extra.value) {
+			return fmt.Errorf("%w: %s checksum mismatch: expected %s, got %s", ErrChecksumDisagreement, extra.algorithm, extra.value, actual)
+		}
+	}
+	return nil
+}
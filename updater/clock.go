@@ -0,0 +1,77 @@
+// updater/clock.go
+package updater
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultClockSkewTolerance is how far the local clock may be off before it is reported
+const defaultClockSkewTolerance = 5 * time.Minute
+
+// clockOffset is how far the server clock was ahead of the local one in the last HTTPS
+// response, in nanoseconds, or zero while the local clock is within the tolerance
+var clockOffset atomic.Int64
+
+// clockSkewed is set while the local clock is off by more than the tolerance, so that the
+// skew is only logged when it is first detected
+var clockSkewed atomic.Bool
+
+// clockTransport measures the local clock against the Date header of HTTPS responses
+type clockTransport struct {
+	base   http.RoundTripper
+	config Config
+}
+
+func (t *clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.TLS == nil {
+		return resp, err
+	}
+
+	serverTime, parseErr := http.ParseTime(resp.Header.Get("Date"))
+	if parseErr != nil {
+		return resp, nil
+	}
+
+	// The header was generated somewhere between sending and receiving
+	received := time.Now()
+	offset := serverTime.Sub(sent.Add(received.Sub(sent) / 2))
+
+	if offset.Abs() <= t.config.clockSkewTolerance() {
+		clockOffset.Store(0)
+		if clockSkewed.Swap(false) {
+			t.config.logf("Local clock is within %s of %s again", t.config.clockSkewTolerance(), req.URL.Host)
+		}
+		return resp, nil
+	}
+
+	clockOffset.Store(int64(offset))
+	if !clockSkewed.Swap(true) {
+		direction := "behind"
+		if offset < 0 {
+			direction = "ahead of"
+		}
+		t.config.logf("Warning: local clock is %s %s %s", offset.Abs().Round(time.Second), direction, req.URL.Host)
+	}
+	return resp, nil
+}
+
+// clockSkewTolerance returns the configured tolerance or its default
+func (c Config) clockSkewTolerance() time.Duration {
+	if c.ClockSkewTolerance > 0 {
+		return c.ClockSkewTolerance
+	}
+	return defaultClockSkewTolerance
+}
+
+// now returns the time for scheduling decisions, corrected by the offset of the update
+// server's clock when UseServerTime is set and the local clock is off beyond the tolerance
+func (c Config) now() time.Time {
+	if c.UseServerTime {
+		return time.Now().Add(time.Duration(clockOffset.Load()))
+	}
+	return time.Now()
+}
@@ -0,0 +1,72 @@
+// updater/command.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrCommandTimeout is returned when an external command, such as a version probe or an
+// install script, did not finish in time. It is killed together with every process it started.
+var ErrCommandTimeout = errors.New("command timed out")
+
+// commandWaitDelay bounds how long output held open by a killed command's children is waited for
+const commandWaitDelay = 5 * time.Second
+
+// hostCommandTimeout bounds the commands that read the battery or OS version of the host
+const hostCommandTimeout = 10 * time.Second
+
+// command returns a command that is killed together with every process it started once
+// ctx is done, so that a hung child cannot outlive the timeout
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// commandError describes err of a command run with ctx, reporting a timeout as
+// ErrCommandTimeout so that a hung command can be told from a failing one
+func commandError(ctx context.Context, name string, timeout time.Duration, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %s did not finish within %s", ErrCommandTimeout, name, timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%s was cancelled: %w", name, ctx.Err())
+	}
+	return err
+}
+
+// hostOutput runs a command reading information about the host and returns its output
+func hostOutput(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hostCommandTimeout)
+	defer cancel()
+
+	out, err := command(ctx, name, args...).Output()
+	if err != nil {
+		return nil, commandError(ctx, name, hostCommandTimeout, err)
+	}
+	return out, nil
+}
+
+// baseContext returns the context external commands run in, cancelled on shutdown
+func (c Config) baseContext() context.Context {
+	if c.Context != nil {
+		return c.Context
+	}
+	return context.Background()
+}
+
+// versionProbeTimeout returns how long a version probe may run
+func (c Config) versionProbeTimeout() time.Duration {
+	if c.VersionProbeTimeout > 0 {
+		return c.VersionProbeTimeout
+	}
+	return defaultVersionProbeTimeout
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+// updater/command_windows.go
+package updater
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup does nothing on Windows, where killProcessGroup walks the process tree instead
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command and every process it started
+func killProcessGroup(cmd *exec.Cmd) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// updater/compat.go
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ErrIncompatible is returned when the running host does not meet a release's requirements
+var ErrIncompatible = errors.New("update is incompatible with this host")
+
+// CompatibilityCheck reports whether the host satisfies the value a release requires,
+// returning a descriptive error when it does not
+type CompatibilityCheck func(required string) error
+
+// builtinChecks are the requirements understood without any configuration
+var builtinChecks = map[string]CompatibilityCheck{
+	"min_kernel":     checkMinKernel,
+	"libc":           checkLibc,
+	"min_os_version": checkMinOSVersion,
+}
+
+// leadingVersion matches the numeric part of versions like "5.15.0-86-generic"
+var leadingVersion = regexp.MustCompile(`^\d+(\.\d+)*`)
+
+// checkRequirements evaluates every requirement against the host. Checks from the config
+// take precedence over built-in ones, and requirements without any check are refused.
+func checkRequirements(config Config, requirements map[string]string) error {
+	keys := make([]string, 0, len(requirements))
+	for key := range requirements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		check, ok := config.CompatibilityChecks[key]
+		if !ok {
+			check, ok = builtinChecks[key]
+		}
+		if !ok {
+			return fmt.Errorf("%w: unknown requirement %q", ErrIncompatible, key)
+		}
+
+		if err := check(requirements[key]); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrIncompatible, key, err)
+		}
+	}
+
+	return nil
+}
+
+// checkMinKernel requires the running kernel release to be at least the given version
+func checkMinKernel(required string) error {
+	var release string
+	switch runtime.GOOS {
+	case "windows":
+		return errors.New("kernel version is not available on windows")
+	case "linux":
+		data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+		if err != nil {
+			return fmt.Errorf("failed to read kernel version: %w", err)
+		}
+		release = string(data)
+	default:
+		out, err := hostOutput("uname", "-r")
+		if err != nil {
+			return fmt.Errorf("failed to read kernel version: %w", err)
+		}
+		release = string(out)
+	}
+
+	return requireAtLeast("kernel", strings.TrimSpace(release), required)
+}
+
+// checkLibc requires the C library, "glibc" or "musl", the host provides
+func checkLibc(required string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("libc requirement only applies to linux, running on %s", runtime.GOOS)
+	}
+
+	libc := "glibc"
+	if matches, _ := filepath.Glob("/lib/ld-musl-*"); len(matches) > 0 {
+		libc = "musl"
+	}
+
+	if !strings.EqualFold(libc, required) {
+		return fmt.Errorf("requires %s, host uses %s", required, libc)
+	}
+	return nil
+}
+
+// checkMinOSVersion requires the operating system release to be at least the given version
+func checkMinOSVersion(required string) error {
+	var osVersion string
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := hostOutput("sw_vers", "-productVersion")
+		if err != nil {
+			return fmt.Errorf("failed to read OS version: %w", err)
+		}
+		osVersion = string(out)
+	case "linux":
+		data, err := os.ReadFile("/etc/os-release")
+		if err != nil {
+			return fmt.Errorf("failed to read OS version: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "VERSION_ID="); ok {
+				osVersion = strings.Trim(value, `"`)
+			}
+		}
+	default:
+		return fmt.Errorf("OS version is not available on %s", runtime.GOOS)
+	}
+
+	return requireAtLeast("OS", strings.TrimSpace(osVersion), required)
+}
+
+// requireAtLeast compares the numeric prefix of actual against required
+func requireAtLeast(what, actual, required string) error {
+	numeric := leadingVersion.FindString(actual)
+	if numeric == "" {
+		return fmt.Errorf("cannot parse %s version %q", what, actual)
+	}
+
+	if compareVersions(numeric, required) < 0 {
+		return fmt.Errorf("requires %s %s or newer, host has %s", what, required, actual)
+	}
+	return nil
+}
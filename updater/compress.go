@@ -0,0 +1,29 @@
+// updater/compress.go
+package updater
+
+import (
+	"strings"
+)
+
+// gzipExt marks a platform asset published gzip compressed
+const gzipExt = ".gz"
+
+// contentSHA256Header carries the SHA-256 of the decompressed content when a server
+// serves a compressed download. The integrity of the compressed transfer itself is
+// left to HTTP and TLS.
+const contentSHA256Header = "X-Content-SHA256"
+
+// isGzipAsset reports whether name is a gzip compressed executable, such as
+// "app-linux-amd64.gz". Compressed tarballs are archives, not executables.
+func isGzipAsset(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, gzipExt) && !strings.HasSuffix(name, ".tar"+gzipExt)
+}
+
+// decompressedName returns the name of an asset once decompressed
+func decompressedName(name string) string {
+	if isGzipAsset(name) {
+		return name[:len(name)-len(gzipExt)]
+	}
+	return name
+}
@@ -0,0 +1,135 @@
+// updater/cosign.go
+package updater
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/sigstore/sigstore-go/pkg/bundle"
+	"github.com/sigstore/sigstore-go/pkg/root"
+	"github.com/sigstore/sigstore-go/pkg/verify"
+)
+
+// ErrCosign is returned when an asset has no Sigstore bundle or its bundle does not prove
+// that the configured identity signed it
+var ErrCosign = errors.New("cosign verification failed")
+
+// cosignBundleExt is appended to an asset's name to find its Sigstore bundle, as written by
+// "cosign sign-blob --bundle"
+const cosignBundleExt = ".sigstore.json"
+
+// maxCosignBundleSize bounds how much of a Sigstore bundle is read
+const maxCosignBundleSize = 1 << 20
+
+// CosignConfig requires every release asset to be signed keylessly through Sigstore, for
+// example by cosign in a CI workflow, with a certificate issued to the expected identity
+type CosignConfig struct {
+	// Identity is the subject the signing certificate must be issued to, such as
+	// "https://github.com/owner/repo/.github/workflows/release.yml@refs/heads/main"
+	Identity string
+	// IdentityRegexp matches the subject instead of Identity, for example to accept
+	// the release workflow running for any tag
+	IdentityRegexp string
+	// Issuer is the OIDC issuer that authenticated the identity, such as
+	// "https://token.actions.githubusercontent.com"
+	Issuer string
+	// TrustedRootPath is a Sigstore trusted_root.json holding the Fulcio and Rekor keys. When
+	// empty, those of the public Sigstore instance are fetched through its TUF repository.
+	TrustedRootPath string
+}
+
+// verifier returns the verifier for Sigstore bundles, which requires the certificate to
+// carry an SCT from the certificate transparency log, and the signature to be logged in
+// Rekor together with the time it was logged
+func (c *CosignConfig) verifier() (*verify.Verifier, error) {
+	var trustedRoot *root.TrustedRoot
+	var err error
+	if c.TrustedRootPath != "" {
+		trustedRoot, err = root.NewTrustedRootFromPath(c.TrustedRootPath)
+	} else {
+		trustedRoot, err = root.FetchTrustedRoot()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Sigstore trusted root: %w", err)
+	}
+
+	return verify.NewVerifier(trustedRoot,
+		verify.WithSignedCertificateTimestamps(1),
+		verify.WithTransparencyLog(1),
+		verify.WithObserverTimestamps(1))
+}
+
+// findCosignBundle attaches the Sigstore bundle of the selected asset when Cosign is set.
+// Like a sidecar checksum, the bundle of a gzipped asset signs the decompressed executable,
+// so it is named after the asset without ".gz".
+func findCosignBundle(config Config, release *github.RepositoryRelease, selected *selectedAsset) error {
+	if config.Cosign == nil {
+		return nil
+	}
+
+	name := decompressedName(selected.name) + cosignBundleExt
+	if selected.cosignBundle = assetByName(release.Assets, name); selected.cosignBundle == nil {
+		return fmt.Errorf("%w: release %s has no %s", ErrCosign, release.GetTagName(), name)
+	}
+	return nil
+}
+
+// verifyCosign checks the file at path against the Sigstore bundle of the selected asset
+func verifyCosign(config Config, path string, selected *selectedAsset) error {
+	if config.Cosign == nil {
+		return nil
+	}
+	if selected.cosignBundle == nil {
+		return fmt.Errorf("%w: %s has no Sigstore bundle", ErrCosign, selected.name)
+	}
+
+	resp, err := fetchAsset(config, selected.cosignBundle.GetBrowserDownloadURL())
+	if err != nil {
+		return fmt.Errorf("failed to download Sigstore bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCosignBundleSize))
+	if err != nil {
+		return fmt.Errorf("failed to read Sigstore bundle: %w", err)
+	}
+	var b bundle.Bundle
+	if err := b.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("%w: malformed bundle %s: %v", ErrCosign, selected.cosignBundle.GetName(), err)
+	}
+
+	verifier := config.cosignVerifier
+	if verifier == nil {
+		if verifier, err = config.Cosign.verifier(); err != nil {
+			return err
+		}
+	}
+	return checkCosign(config.Cosign, verifier, &b, path)
+}
+
+// checkCosign verifies that entity signs the SHA-256 digest of the file at path with a
+// certificate issued to the configured identity
+func checkCosign(cosign *CosignConfig, verifier *verify.Verifier, entity verify.SignedEntity, path string) error {
+	identity, err := verify.NewShortCertificateIdentity(cosign.Issuer, "", cosign.Identity, cosign.IdentityRegexp)
+	if err != nil {
+		return fmt.Errorf("invalid cosign identity: %w", err)
+	}
+
+	digest, err := hashFile(path, SHA256)
+	if err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return err
+	}
+
+	policy := verify.NewPolicy(verify.WithArtifactDigest(SHA256, raw), verify.WithCertificateIdentity(identity))
+	if _, err := verifier.Verify(entity, policy); err != nil {
+		return fmt.Errorf("%w: %v", ErrCosign, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sigstore/sigstore-go/pkg/testing/ca"
+	"github.com/sigstore/sigstore-go/pkg/verify"
+)
+
+func TestCheckCosign(t *testing.T) {
+	const (
+		workflow = "https://github.com/owner/app/.github/workflows/release.yml@refs/tags/v1.1.0"
+		actions  = "https://token.actions.githubusercontent.com"
+	)
+
+	sigstore, err := ca.NewVirtualSigstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := sigstore.Sign(workflow, actions, []byte("binary 1.1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The virtual Sigstore issues no SCTs, so unlike the public instance it is not asked for any
+	verifier, err := verify.NewVerifier(sigstore, verify.WithTransparencyLog(1), verify.WithObserverTimestamps(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cosign   CosignConfig
+		contents string
+		wantErr  bool
+	}{
+		{name: "identity", cosign: CosignConfig{Identity: workflow, Issuer: actions}, contents: "binary 1.1.0"},
+		{name: "identity pattern", cosign: CosignConfig{IdentityRegexp: `^https://github\.com/owner/app/\.github/workflows/release\.yml@refs/tags/v`, Issuer: actions}, contents: "binary 1.1.0"},
+		{name: "other identity", cosign: CosignConfig{Identity: "https://github.com/mallory/app/.github/workflows/release.yml@refs/tags/v1.1.0", Issuer: actions}, contents: "binary 1.1.0", wantErr: true},
+		{name: "other issuer", cosign: CosignConfig{Identity: workflow, Issuer: "https://accounts.google.com"}, contents: "binary 1.1.0", wantErr: true},
+		{name: "other artifact", cosign: CosignConfig{Identity: workflow, Issuer: actions}, contents: "binary 6.6.6", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app")
+			writeFile(t, path, tt.contents)
+
+			err := checkCosign(&tt.cosign, verifier, entity, path)
+			if tt.wantErr != errors.Is(err, ErrCosign) || (!tt.wantErr && err != nil) {
+				t.Errorf("checkCosign() error = %v, want cosign failure %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCosignRejectsBeforeInstall(t *testing.T) {
+	tests := []struct {
+		name string
+		// bundle is the contents of the asset's bundle, none when empty
+		bundle string
+	}{
+		{name: "missing bundle"},
+		{name: "malformed bundle", bundle: `{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPipelineFixture(t)
+			if tt.bundle != "" {
+				f.files[f.asset+cosignBundleExt] = tt.bundle
+			}
+
+			config := f.config
+			config.Cosign = &CosignConfig{Identity: "https://github.com/owner/app/.github/workflows/release.yml@refs/tags/v1.1.0", Issuer: "https://token.actions.githubusercontent.com"}
+			if _, err := CheckAndUpdate(config); !errors.Is(err, ErrCosign) {
+				t.Fatalf("CheckAndUpdate() error = %v, want ErrCosign", err)
+			}
+
+			assertFile(t, f.exe, "binary 1.0.0")
+			assertMissing(t, backupPath(f.exe, 1))
+			assertNoDownloads(t, config)
+			if _, err := os.Stat(config.stagedPath("1.1.0")); err == nil {
+				t.Errorf("update staged despite failing cosign verification")
+			}
+		})
+	}
+}
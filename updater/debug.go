@@ -0,0 +1,85 @@
+// updater/debug.go
+package updater
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// sensitiveHeaders are never logged verbatim
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// sensitiveParams mark query parameters, such as those of signed asset URLs, that are never logged verbatim
+var sensitiveParams = []string{"token", "signature", "sig", "credential"}
+
+// debugTransport logs every request and response when Config.Debug is set
+type debugTransport struct {
+	base   http.RoundTripper
+	config Config
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := redactURL(req.URL)
+	t.config.logf("HTTP %s %s %s", req.Method, target, formatHeaders(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.config.logf("HTTP %s %s failed: %v", req.Method, target, err)
+		return nil, err
+	}
+	t.config.logf("HTTP %s %s: %s %s", req.Method, target, resp.Status, formatHeaders(resp.Header))
+
+	// Binary downloads are not worth quoting, anything else is peeked at and put back
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/octet-stream" {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSnippet))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(snippet), resp.Body), resp.Body}
+		t.config.logf("HTTP %s %s body: %q", req.Method, target, snippet)
+	}
+
+	return resp, nil
+}
+
+// formatHeaders renders headers sorted by name, redacting credentials
+func formatHeaders(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				value = "REDACTED"
+			}
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return "[" + strings.Join(parts, "; ") + "]"
+}
+
+// redactURL returns u with its password and sensitive query parameters replaced
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		for _, sensitive := range sensitiveParams {
+			if strings.Contains(strings.ToLower(name), sensitive) {
+				query.Set(name, "REDACTED")
+			}
+		}
+	}
+
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.Redacted()
+}
@@ -0,0 +1,102 @@
+// updater/detect.go
+package updater
+
+import (
+	"context"
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Sources the installed version of a managed executable can be detected from
+const (
+	// VersionSourceProbe runs the executable with VersionProbe, or --version when unset
+	VersionSourceProbe = "probe"
+	// VersionSourceBuildInfo reads a -X version flag or the module version embedded in a Go binary
+	VersionSourceBuildInfo = "buildinfo"
+	// VersionSourceFile reads VersionFile
+	VersionSourceFile = "file"
+)
+
+// ldflagsVersion matches a version set at link time, as in -X main.version=1.2.3
+var ldflagsVersion = regexp.MustCompile(`-X[= ]['"]?\S*\.[Vv]ersion=([^\s'"]+)`)
+
+// pseudoVersion matches the module versions Go derives from a commit, such as
+// v0.0.0-20240101120000-abcdef123456
+var pseudoVersion = regexp.MustCompile(`\d{14}-[0-9a-f]{12}`)
+
+// detectVersion determines the installed version of the executable from VersionSource.
+// Detection failing is no reason to skip the check, so CurrentVersion is used instead.
+func (c Config) detectVersion() string {
+	if c.VersionSource == "" {
+		return c.CurrentVersion
+	}
+
+	version, err := DetectVersion(c)
+	if err != nil {
+		c.logf("Failed to detect the installed version, assuming %s: %v", c.CurrentVersion, err)
+		return c.CurrentVersion
+	}
+	if version != c.CurrentVersion {
+		c.logf("Detected installed version %s", version)
+	}
+	return version
+}
+
+// DetectVersion returns the version of the executable at ExecutablePath, read as VersionSource says
+func DetectVersion(config Config) (string, error) {
+	var text string
+	switch config.VersionSource {
+	case VersionSourceProbe:
+		args := config.VersionProbe
+		if len(args) == 0 {
+			args = []string{"--version"}
+		}
+
+		timeout := config.versionProbeTimeout()
+		ctx, cancel := context.WithTimeout(config.baseContext(), timeout)
+		defer cancel()
+
+		out, err := command(ctx, config.ExecutablePath, args...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("failed to run %s: %w", config.ExecutablePath, commandError(ctx, "version probe", timeout, err))
+		}
+		text = string(out)
+	case VersionSourceBuildInfo:
+		info, err := buildinfo.ReadFile(config.ExecutablePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read build info: %w", err)
+		}
+		for _, setting := range info.Settings {
+			if setting.Key != "-ldflags" {
+				continue
+			}
+			if match := ldflagsVersion.FindStringSubmatch(setting.Value); match != nil {
+				text = match[1]
+			}
+		}
+		// Builds from a checkout are stamped with a pseudo-version naming no release
+		if v := info.Main.Version; text == "" && v != "(devel)" && !pseudoVersion.MatchString(v) {
+			text = v
+		}
+		if text == "" {
+			return "", fmt.Errorf("%s has no version in its build info", config.ExecutablePath)
+		}
+	case VersionSourceFile:
+		data, err := os.ReadFile(config.VersionFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read version file: %w", err)
+		}
+		text = string(data)
+	default:
+		return "", fmt.Errorf("unknown version source %q", config.VersionSource)
+	}
+
+	version := reportedVersion.FindString(text)
+	if version == "" {
+		return "", fmt.Errorf("no version found in %q", strings.TrimSpace(text))
+	}
+	return strings.TrimPrefix(version, "v"), nil
+}
@@ -0,0 +1,162 @@
+// updater/directory.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// directoryScheme prefixes the download URLs of files in DirectorySource, which are served
+// from the directory instead of over the network
+const directoryScheme = "dir"
+
+// directoryTransport serves "dir:///<version>/<name>" URLs from the directory source
+func (c Config) directoryTransport() http.RoundTripper {
+	t := &http.Transport{}
+	t.RegisterProtocol(directoryScheme, http.NewFileTransport(http.Dir(c.DirectorySource)))
+	return t
+}
+
+// checkAndUpdateDirectory performs the update check against the version directories in
+// DirectorySource. Each "<version>" directory is treated like a GitHub release holding its
+// files as assets, so that it is verified the same way: through its manifest and signature
+// when present, otherwise through a checksum file next to the binary.
+func checkAndUpdateDirectory(config Config, timings *Timings) (*Result, error) {
+	if config.SourceArchive != "" {
+		return nil, errors.New("source archive updates are not supported from a directory source")
+	}
+	// Every version is looked up in the directory, never in other repos
+	config.FallbackRepos = nil
+
+	checkStart := time.Now()
+	version, err := latestDirectoryVersion(config)
+	timings.Check = time.Since(checkStart)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		return &Result{Version: config.CurrentVersion}, nil
+	}
+
+	switch cmp := compareVersions(version, config.CurrentVersion); {
+	case cmp == 0 && !config.ForceReinstall && !config.RepairCorrupted:
+		return &Result{Version: config.CurrentVersion}, nil
+	case cmp < 0 && !config.AllowDowngrade:
+		if config.PinnedVersion != "" {
+			return nil, fmt.Errorf("pinned version %s is older than current version %s and downgrades are not allowed", version, config.CurrentVersion)
+		}
+		return &Result{Version: config.CurrentVersion}, nil
+	case cmp != 0:
+		config.logf("Update available: %s", version)
+	}
+
+	for step := 0; step < maxUpgradeSteps; step++ {
+		release, err := directoryRelease(config, version)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := applyRelease(context.Background(), nil, config, release, version, timings)
+		var required *minVersionError
+		if !errors.As(err, &required) {
+			return result, err
+		}
+
+		version = required.minVersion
+		config.logf("Version %s requires version %s first, installing it", required.version, version)
+		if state, err := LoadState(config.ExecutablePath); err == nil && state.isReverted(version) {
+			return nil, fmt.Errorf("required version %s was reverted after crashing", version)
+		}
+	}
+
+	return nil, fmt.Errorf("no upgrade path from %s found within %d releases", config.CurrentVersion, maxUpgradeSteps)
+}
+
+// latestDirectoryVersion returns the version to install from DirectorySource, or an empty
+// string when there is none. Directories starting with "." are ignored, so that a drop can
+// be written under a temporary name and renamed into place once complete.
+func latestDirectoryVersion(config Config) (string, error) {
+	entries, err := os.ReadDir(config.DirectorySource)
+	if err != nil {
+		return "", fmt.Errorf("failed to read directory source: %w", err)
+	}
+
+	state, err := LoadState(config.ExecutablePath)
+	if err != nil {
+		return "", err
+	}
+
+	pinned := strings.TrimPrefix(config.PinnedVersion, "v")
+	var latest string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		version := strings.TrimPrefix(entry.Name(), "v")
+		if _, err := parseVersion(version); err != nil {
+			continue
+		}
+
+		switch {
+		case pinned != "":
+			if compareVersions(version, pinned) != 0 {
+				continue
+			}
+		case !config.includesPrerelease() && IsPrerelease(version):
+			continue
+		case skipEntry(version, config.SkipVersions) != "" || state.isReverted(version):
+			continue
+		}
+
+		if latest == "" || compareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+
+	if latest == "" && pinned != "" {
+		return "", fmt.Errorf("pinned version %s not found in %s", pinned, config.DirectorySource)
+	}
+	return latest, nil
+}
+
+// directoryRelease describes the directory of version as a release whose assets are the
+// files it holds
+func directoryRelease(config Config, version string) (*github.RepositoryRelease, error) {
+	name := version
+	if _, err := os.Stat(filepath.Join(config.DirectorySource, name)); os.IsNotExist(err) {
+		name = "v" + version
+	}
+
+	entries, err := os.ReadDir(filepath.Join(config.DirectorySource, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version %s: %w", version, err)
+	}
+
+	release := &github.RepositoryRelease{
+		TagName:    github.String(name),
+		Prerelease: github.Bool(IsPrerelease(version)),
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		u := url.URL{Scheme: directoryScheme, Path: path.Join("/", name, entry.Name())}
+		release.Assets = append(release.Assets, &github.ReleaseAsset{
+			Name:               github.String(entry.Name()),
+			Size:               github.Int(int(info.Size())),
+			BrowserDownloadURL: github.String(u.String()),
+		})
+	}
+	return release, nil
+}
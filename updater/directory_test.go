@@ -0,0 +1,47 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v40/github"
+)
+
+func TestLatestDirectoryVersionPrerelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		include *bool
+		want    string
+	}{
+		{name: "unset", want: "1.1.0"},
+		{name: "excluded", include: github.Bool(false), want: "1.1.0"},
+		{name: "included", include: github.Bool(true), want: "2.0.0-beta.1"},
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "releases")
+	for _, version := range []string{"1.0.0", "1.1.0", "v2.0.0-beta.1"} {
+		if err := os.MkdirAll(filepath.Join(source, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{
+				ExecutablePath:    filepath.Join(dir, "app"),
+				DirectorySource:   source,
+				IncludePrerelease: tt.include,
+			}
+
+			version, err := latestDirectoryVersion(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.want {
+				t.Errorf("latestDirectoryVersion() = %s, want %s", version, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// updater/etag.go
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxCachedResponses bounds how many API responses are kept for conditional requests
+const maxCachedResponses = 64
+
+// cachedResponse is an API response kept to answer a 304 Not Modified with
+type cachedResponse struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// responseCache holds the last response for each API URL and credential. It outlives the
+// client of a single check, so that every check after the first can be conditional.
+var responseCache = struct {
+	sync.Mutex
+	entries map[string]cachedResponse
+}{entries: map[string]cachedResponse{}}
+
+// etagTransport sends If-None-Match with the ETag of the last response for the same URL
+// and replays that response when GitHub answers 304 Not Modified, which does not count
+// against the rate limit
+type etagTransport struct {
+	base http.RoundTripper
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	responseCache.Lock()
+	cached, ok := responseCache.entries[key]
+	responseCache.Unlock()
+
+	if ok {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		resp.Body.Close()
+		// The 304 carries current rate limit headers, the cached ones describe the content
+		header := cached.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Header = header
+		resp.Body = io.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		storeResponse(key, cachedResponse{etag: resp.Header.Get("ETag"), header: resp.Header.Clone(), body: body})
+	}
+	return resp, nil
+}
+
+// cacheKey identifies a request by URL and credential, so that responses a token may see
+// are never replayed to requests without it
+func cacheKey(req *http.Request) string {
+	key := req.URL.String()
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		digest := sha256.Sum256([]byte(auth))
+		key += " " + hex.EncodeToString(digest[:])
+	}
+	return key
+}
+
+// storeResponse caches a response, evicting an arbitrary one when the cache is full
+func storeResponse(key string, response cachedResponse) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	if _, ok := responseCache.entries[key]; !ok && len(responseCache.entries) >= maxCachedResponses {
+		for evict := range responseCache.entries {
+			delete(responseCache.entries, evict)
+			break
+		}
+	}
+	responseCache.entries[key] = response
+}
@@ -0,0 +1,32 @@
+// updater/faults.go
+package updater
+
+import "io"
+
+// faultPhase is a step of the update pipeline at which tests can force a failure
+type faultPhase string
+
+const (
+	faultDownload faultPhase = "download"
+	faultWrite    faultPhase = "write"
+	faultChecksum faultPhase = "checksum"
+	faultRename   faultPhase = "rename"
+	faultHealth   faultPhase = "health"
+)
+
+// fault returns the error injected at phase, if any. Only tests inject faults.
+func (c Config) fault(phase faultPhase) error {
+	return c.faults[phase]
+}
+
+// failingWriter writes half of the first chunk to w and then fails with err, like a
+// disk filling up partway through a download
+type failingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	n, _ := f.w.Write(p[:len(p)/2])
+	return n, f.err
+}
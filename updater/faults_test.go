@@ -0,0 +1,228 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// pipelineFixture is an installed executable at version 1.0.0 and a fake GitHub API
+// offering version 1.1.0 of it
+type pipelineFixture struct {
+	config Config
+	exe    string
+	asset  string
+	// tag is the tag of the latest release
+	tag string
+	// files are the release assets by name, downloads counts the requests for each and
+	// for the "tarball"
+	files     map[string]string
+	downloads map[string]int
+	// tarball is the source tarball of the release
+	tarball []byte
+}
+
+// newPipelineFixture sets up an executable and a GitHub API to update it from
+func newPipelineFixture(t *testing.T) *pipelineFixture {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the executable is replaced by a batch file on Windows")
+	}
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "app")
+	writeFile(t, exe, "binary 1.0.0")
+
+	staging := filepath.Join(dir, "staging")
+	if err := os.Mkdir(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	asset := "app-" + runtime.GOOS + "-" + runtime.GOARCH
+	sum := sha256.Sum256([]byte("binary 1.1.0"))
+	files := map[string]string{
+		asset:             "binary 1.1.0",
+		asset + ".sha256": hex.EncodeToString(sum[:]) + "  " + asset + "\n",
+	}
+
+	f := &pipelineFixture{exe: exe, asset: asset, tag: "v1.1.0", files: files, downloads: map[string]int{}}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/repos/owner/app/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		release := &github.RepositoryRelease{
+			TagName:    github.String(f.tag),
+			TarballURL: github.String(server.URL + "/tarball"),
+		}
+		for name, contents := range f.files {
+			release.Assets = append(release.Assets, &github.ReleaseAsset{
+				Name:               github.String(name),
+				Size:               github.Int(len(contents)),
+				BrowserDownloadURL: github.String(server.URL + "/download/" + name),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(release)
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/download/")
+		contents, ok := f.files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.downloads[name]++
+		io.WriteString(w, contents)
+	})
+	mux.HandleFunc("/tarball", func(w http.ResponseWriter, r *http.Request) {
+		f.downloads["tarball"]++
+		w.Write(f.tarball)
+	})
+
+	f.config = Config{
+		GithubRepo:     "owner/app",
+		ExecutablePath: exe,
+		CurrentVersion: "1.0.0",
+		StagingDir:     staging,
+		Logger:         log.New(io.Discard, "", 0),
+		apiURL:         server.URL + "/",
+	}
+	return f
+}
+
+// writeFile writes contents to path, failing the test on error
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertFile checks that path holds contents
+func assertFile(t *testing.T, path, contents string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", filepath.Base(path), err)
+	}
+	if string(data) != contents {
+		t.Errorf("%s holds %q, want %q", filepath.Base(path), data, contents)
+	}
+}
+
+// assertMissing checks that path does not exist
+func assertMissing(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists, want it removed", filepath.Base(path))
+	}
+}
+
+// assertNoDownloads checks that no partial download was left in the staging directory
+func assertNoDownloads(t *testing.T, config Config) {
+	t.Helper()
+	leftover, err := filepath.Glob(filepath.Join(config.StagingDir, "update_*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftover) > 0 {
+		t.Errorf("downloads left behind: %v", leftover)
+	}
+}
+
+func TestPipelineRecoversFromFaults(t *testing.T) {
+	injected := errors.New("injected fault")
+
+	tests := []struct {
+		phase faultPhase
+		// staged is whether the verified download is kept for the next attempt
+		staged bool
+		// backedUp is whether the executable had already been backed up and replaced
+		backedUp bool
+	}{
+		{phase: faultDownload},
+		{phase: faultChecksum},
+		{phase: faultRename, staged: true},
+		{phase: faultHealth, backedUp: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.phase), func(t *testing.T) {
+			f := newPipelineFixture(t)
+
+			config := f.config
+			config.faults = map[faultPhase]error{tt.phase: injected}
+			if _, err := CheckAndUpdate(config); !errors.Is(err, injected) {
+				t.Fatalf("CheckAndUpdate() error = %v, want the injected fault", err)
+			}
+
+			// The running version is untouched, or restored when the new one failed its check
+			assertFile(t, f.exe, "binary 1.0.0")
+			if tt.backedUp {
+				assertFile(t, backupPath(f.exe, 1), "binary 1.0.0")
+			} else {
+				assertMissing(t, backupPath(f.exe, 1))
+			}
+			assertNoDownloads(t, config)
+			if tt.staged {
+				assertFile(t, config.stagedPath("1.1.0"), "binary 1.1.0")
+			} else {
+				assertMissing(t, config.stagedPath("1.1.0"))
+			}
+
+			// Without the fault the next check installs the update
+			result, err := CheckAndUpdate(f.config)
+			if err != nil {
+				t.Fatalf("CheckAndUpdate() after the fault: %v", err)
+			}
+			if !result.Updated || result.Version != "1.1.0" {
+				t.Errorf("CheckAndUpdate() after the fault = %+v, want version 1.1.0 installed", result)
+			}
+			assertFile(t, f.exe, "binary 1.1.0")
+			assertFile(t, backupPath(f.exe, 1), "binary 1.0.0")
+			assertMissing(t, config.stagedPath("1.1.0"))
+			assertNoDownloads(t, config)
+
+			// A staged download is reused rather than fetched again
+			want := 2
+			if tt.staged || tt.phase == faultDownload {
+				want = 1
+			}
+			if got := f.downloads[f.asset]; got != want {
+				t.Errorf("asset downloaded %d times, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDownloadRemovesPartialFileWhenDiskFills(t *testing.T) {
+	f := newPipelineFixture(t)
+
+	config := f.config
+	config.faults = map[faultPhase]error{
+		faultWrite: &os.PathError{Op: "write", Path: "update.bin", Err: syscall.ENOSPC},
+	}
+	_, err := CheckAndUpdate(config)
+	if !errors.Is(err, ErrInsufficientSpace) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("CheckAndUpdate() error = %v, want ErrInsufficientSpace wrapping ENOSPC", err)
+	}
+
+	assertNoDownloads(t, config)
+	assertMissing(t, config.stagedPath("1.1.0"))
+	assertFile(t, f.exe, "binary 1.0.0")
+	assertMissing(t, backupPath(f.exe, 1))
+}
@@ -0,0 +1,230 @@
+// updater/github.go
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v40/github"
+	"golang.org/x/oauth2"
+)
+
+// maxErrorSnippet bounds how much of an unexpected response body is quoted in errors
+const maxErrorSnippet = 256
+
+// newGithubClient returns a GitHub API client, authenticated when a token is configured.
+// Responses are cached so that repeated checks are conditional requests.
+func newGithubClient(config Config) *github.Client {
+	var transport http.RoundTripper = &etagTransport{base: &jsonTransport{base: config.transport()}}
+	if config.GithubToken != "" {
+		transport = &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.GithubToken}),
+			Base:   transport,
+		}
+	}
+
+	client := github.NewClient(&http.Client{Transport: transport})
+	if config.apiURL != "" {
+		client.BaseURL, _ = url.Parse(config.apiURL)
+	}
+	return client
+}
+
+// defaultReleaseScanDepth is how many releases are scanned for pre-releases by default
+const defaultReleaseScanDepth = 100
+
+// maxReleasesPerPage is the largest page size the releases API accepts
+const maxReleasesPerPage = 100
+
+// getLatestRelease returns the newest release. With IncludePrerelease set, pre-releases are
+// considered as well, scanning up to ReleaseScanDepth releases page by page. Releases are
+// listed newest first, so scanning stops at the first one not newer than the running version.
+// Should GitHub report a pre-release as latest while they are excluded, the releases are
+// scanned for the newest stable one instead. An existing repository without releases yields nil.
+func getLatestRelease(ctx context.Context, client *github.Client, owner, repo string, config Config) (*github.RepositoryRelease, error) {
+	if !config.includesPrerelease() {
+		release, resp, err := client.Repositories.GetLatestRelease(ctx, owner, repo)
+		// GitHub answers 404 when there is no release yet, which is normal for a new repository,
+		// but also when the repository does not exist or the token cannot access it
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			if _, _, err := client.Repositories.Get(ctx, owner, repo); err != nil {
+				return nil, fmt.Errorf("failed to find repository %s/%s: %w", owner, repo, err)
+			}
+			config.logNoReleases(owner, repo)
+			return nil, nil
+		}
+		if err != nil || !config.excludesPrerelease(release) {
+			return release, err
+		}
+	}
+
+	depth := config.ReleaseScanDepth
+	if depth <= 0 {
+		depth = defaultReleaseScanDepth
+	}
+
+	var latest *github.RepositoryRelease
+	var latestVersion string
+	opts := &github.ListOptions{PerPage: min(depth, maxReleasesPerPage)}
+	scanned := 0
+	for scanned < depth {
+		releases, resp, err := client.Repositories.ListReleases(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, release := range releases {
+			if scanned++; scanned > depth {
+				break
+			}
+			if release.GetDraft() || (release.GetPrerelease() && !config.includesPrerelease()) {
+				continue
+			}
+
+			version, err := releaseVersion(config, release)
+			if err != nil {
+				config.logf("Ignoring release: %v", err)
+				continue
+			}
+			if latest == nil || compareVersions(version, latestVersion) > 0 {
+				latest, latestVersion = release, version
+			}
+			if compareVersions(version, config.CurrentVersion) <= 0 {
+				return latest, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if latest == nil && scanned > 0 {
+		return nil, fmt.Errorf("no releases found in the latest %d", depth)
+	}
+	if latest == nil {
+		config.logNoReleases(owner, repo)
+	}
+	return latest, nil
+}
+
+// noReleasesLogged records the repositories already reported to have no releases
+var noReleasesLogged sync.Map
+
+// logNoReleases reports once per repository, in debug mode, that it has no releases
+func (c Config) logNoReleases(owner, repo string) {
+	if _, logged := noReleasesLogged.LoadOrStore(owner+"/"+repo, true); !logged && c.Debug {
+		c.logf("Repository %s/%s has no releases yet", owner, repo)
+	}
+}
+
+// includesPrerelease reports whether pre-releases are considered for the latest release
+func (c Config) includesPrerelease() bool {
+	return c.IncludePrerelease != nil && *c.IncludePrerelease
+}
+
+// excludesPrerelease reports whether release must not be installed for being a pre-release
+func (c Config) excludesPrerelease(release *github.RepositoryRelease) bool {
+	return c.IncludePrerelease != nil && !*c.IncludePrerelease && release.GetPrerelease()
+}
+
+// releaseVersion returns the version of a release, taken from its tag. When the tag is missing
+// or not a version, AssetVersionPattern extracts it from the first asset name it matches,
+// using the group named "version" or else the first group.
+func releaseVersion(config Config, release *github.RepositoryRelease) (string, error) {
+	tag := strings.TrimPrefix(release.GetTagName(), "v")
+	if _, err := parseVersion(tag); err == nil || config.AssetVersionPattern == "" {
+		if tag == "" {
+			return "", fmt.Errorf("release %q has no tag", release.GetName())
+		}
+		return tag, nil
+	}
+
+	pattern, err := regexp.Compile(config.AssetVersionPattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid asset version pattern: %w", err)
+	}
+	group := pattern.SubexpIndex("version")
+	if group < 0 {
+		group = 1
+	}
+	if pattern.NumSubexp() < group {
+		return "", fmt.Errorf("asset version pattern %q has no group to extract the version from", config.AssetVersionPattern)
+	}
+
+	for _, asset := range release.Assets {
+		name := asset.GetName()
+		if isSidecar(strings.ToLower(name)) {
+			continue
+		}
+		if match := pattern.FindStringSubmatch(name); match != nil {
+			version := strings.TrimPrefix(match[group], "v")
+			if _, err := parseVersion(version); err != nil {
+				return "", fmt.Errorf("asset %s: %w", name, err)
+			}
+			return version, nil
+		}
+	}
+
+	return "", fmt.Errorf("release %q has tag %q and no asset matching %q", release.GetName(), release.GetTagName(), config.AssetVersionPattern)
+}
+
+// getReleaseByVersion looks up a release by its tag, with or without a "v" prefix
+func getReleaseByVersion(ctx context.Context, client *github.Client, owner, repo, version string) (*github.RepositoryRelease, error) {
+	release, _, err := client.Repositories.GetReleaseByTag(ctx, owner, repo, version)
+	if err == nil {
+		return release, nil
+	}
+
+	release, _, vErr := client.Repositories.GetReleaseByTag(ctx, owner, repo, "v"+version)
+	if vErr == nil {
+		return release, nil
+	}
+
+	return nil, fmt.Errorf("failed to get release %s: %w", version, err)
+}
+
+// jsonTransport rejects successful API responses that are not JSON. Captive portals and
+// intercepting proxies answer with an HTML page and a 200 status, which would otherwise
+// surface as a cryptic decoding error.
+type jsonTransport struct {
+	base http.RoundTripper
+}
+
+func (t *jsonTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	if err := checkJSONResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkJSONResponse returns an error quoting the start of the body when resp is not JSON
+func checkJSONResponse(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSnippet))
+	return fmt.Errorf("expected JSON from %s, got %s - possible captive portal or proxy: %q",
+		resp.Request.URL.Host, contentType, strings.TrimSpace(string(snippet)))
+}
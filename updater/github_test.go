@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// releasesServer serves releases as the release list of owner/repo, newest first, and
+// latest as its latest release, or no latest release when it is nil. handlers serve
+// further API paths.
+func releasesServer(t *testing.T, handlers map[string]http.HandlerFunc, latest *github.RepositoryRelease, releases ...*github.RepositoryRelease) *github.Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		if latest == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(latest)
+	})
+	mux.HandleFunc("/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(releases)
+	})
+	for path, handler := range handlers {
+		mux.HandleFunc(path, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client
+}
+
+func TestGetLatestReleasePrerelease(t *testing.T) {
+	stable := &github.RepositoryRelease{TagName: github.String("v1.1.0")}
+	beta := &github.RepositoryRelease{TagName: github.String("v2.0.0-beta.1"), Prerelease: github.Bool(true)}
+
+	tests := []struct {
+		name    string
+		include *bool
+		// latest is what GitHub reports as the latest release
+		latest *github.RepositoryRelease
+		want   string
+	}{
+		{name: "unset uses the latest release", latest: stable, want: "v1.1.0"},
+		{name: "unset trusts a pre-release reported as latest", latest: beta, want: "v2.0.0-beta.1"},
+		{name: "excluded skips a pre-release reported as latest", include: github.Bool(false), latest: beta, want: "v1.1.0"},
+		{name: "excluded uses the latest release", include: github.Bool(false), latest: stable, want: "v1.1.0"},
+		{name: "included picks the higher pre-release", include: github.Bool(true), latest: stable, want: "v2.0.0-beta.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := releasesServer(t, nil, tt.latest, beta, stable)
+			config := Config{
+				CurrentVersion:    "1.0.0",
+				IncludePrerelease: tt.include,
+				Logger:            log.New(io.Discard, "", 0),
+			}
+
+			release, err := getLatestRelease(context.Background(), client, "owner", "repo", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := release.GetTagName(); got != tt.want {
+				t.Errorf("getLatestRelease() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLatestReleaseNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		exists  bool
+		wantErr bool
+	}{
+		{name: "repository without releases", exists: true},
+		{name: "missing repository", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := releasesServer(t, map[string]http.HandlerFunc{
+				"/repos/owner/repo": func(w http.ResponseWriter, r *http.Request) {
+					if !tt.exists {
+						http.NotFound(w, r)
+						return
+					}
+					json.NewEncoder(w).Encode(&github.Repository{FullName: github.String("owner/repo")})
+				},
+			}, nil)
+			config := Config{CurrentVersion: "1.0.0", Logger: log.New(io.Discard, "", 0)}
+
+			release, err := getLatestRelease(context.Background(), client, "owner", "repo", config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getLatestRelease() error = %v, want error %v", err, tt.wantErr)
+			}
+			if release != nil {
+				t.Errorf("getLatestRelease() = %s, want no release", release.GetTagName())
+			}
+		})
+	}
+}
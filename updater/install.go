@@ -0,0 +1,100 @@
+// updater/install.go
+package updater
+
+import (
+	"fmt"
+	"time"
+)
+
+// installSteps are the parts of an install that differ between an executable and a
+// source archive
+type installSteps struct {
+	// apply swaps the verified update in
+	apply func() error
+	// keep is called when the update was not applied, to keep the download for the next
+	// attempt or drop it
+	keep func()
+	// rollback restores what apply replaced
+	rollback func() error
+}
+
+// applyAt returns when an update of version may be applied, or the zero time when it may
+// be applied now. Outside the maintenance window, with the update budget used up or within
+// the restart cooldown it has to wait, unless it is mandatory.
+func (c Config) applyAt(version string, selected *selectedAsset) (time.Time, error) {
+	if selected.enforced(c) {
+		return time.Time{}, nil
+	}
+
+	if c.MaintenanceWindow != nil {
+		now := c.now()
+		open, err := c.MaintenanceWindow.Contains(now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !open {
+			applyAt, _ := c.MaintenanceWindow.Next(now)
+			c.logf("Maintenance window closed, deferring update %s until %s", version, applyAt.Format(time.RFC3339))
+			return applyAt, nil
+		}
+	}
+
+	if applyAt := c.nextBudgetSlot(); !applyAt.IsZero() {
+		c.logf("Update budget of %d per %s used up, deferring update %s until %s", c.MaxUpdatesPerWindow, c.updateBudgetWindow(), version, applyAt.Format(time.RFC3339))
+		return applyAt, nil
+	}
+
+	if applyAt := c.cooldownEnd(); !applyAt.IsZero() {
+		c.logf("Restart cooldown of %s not over, deferring update %s until %s", c.RestartCooldown, version, applyAt.Format(time.RFC3339))
+		return applyAt, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// installReady applies an update of version that nothing holds back any more. It waits out
+// the apply grace period, runs the install scripts around the apply and records the
+// install, rolling back when the post-install script fails.
+func installReady(config Config, version string, selected *selectedAsset, timings *Timings, steps installSteps) (*Result, error) {
+	if err := config.awaitApply(version); err != nil {
+		// Cancelled while waiting, the verified download is kept for the next run
+		steps.keep()
+		return nil, fmt.Errorf("failed to apply update %s: %w", version, err)
+	}
+
+	if err := config.runScript(selected.preInstall, version); err != nil {
+		steps.keep()
+		return nil, failed(err)
+	}
+
+	applyStart := time.Now()
+	err := steps.apply()
+	timings.Apply = time.Since(applyStart)
+	if err != nil {
+		steps.keep()
+		return nil, failed(err)
+	}
+
+	if err = config.fault(faultHealth); err == nil {
+		err = config.runScript(selected.postInstall, version)
+	}
+	if err != nil {
+		if config.DisableBackup {
+			config.logf("Post-install script failed and backups are disabled, keeping version %s", version)
+		} else if rollbackErr := steps.rollback(); rollbackErr != nil {
+			config.logf("Failed to restore version %s after its post-install script failed: %v", config.CurrentVersion, rollbackErr)
+		} else {
+			config.logf("Restored version %s after the post-install script of %s failed", config.CurrentVersion, version)
+		}
+		return nil, failed(err)
+	}
+
+	if err := recordInstall(config, version); err != nil {
+		config.logf("Failed to record install: %v", err)
+	}
+	if err := writeReadyMarker(config, version); err != nil {
+		return nil, err
+	}
+
+	return &Result{Updated: true, Version: version, Urgency: selected.urgency}, nil
+}
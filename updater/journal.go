@@ -0,0 +1,133 @@
+// updater/journal.go
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// journalRename is a single rename of a swap
+type journalRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// swapJournal records the renames of a swap before they are made, so that a swap
+// interrupted by a crash can be undone on the next start instead of leaving a mix of
+// old and new files behind
+type swapJournal struct {
+	Renames []journalRename `json:"renames"`
+	// Done is the number of renames known to be complete
+	Done int `json:"done"`
+}
+
+// journalPath returns the path of the journal for swaps of TargetDir
+func (c Config) journalPath() string {
+	return filepath.Clean(c.TargetDir) + ".journal"
+}
+
+// save writes the journal and flushes it to disk before anything it describes happens
+func (j *swapJournal) save(path string) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// rollback undoes the renames in reverse order. A rename counts as made when its source
+// is gone and its destination exists, which also covers one made just before a crash
+// and not yet recorded.
+func (j *swapJournal) rollback() error {
+	for i := len(j.Renames) - 1; i >= 0; i-- {
+		r := j.Renames[i]
+		if _, err := os.Stat(r.From); err == nil {
+			continue
+		}
+		if _, err := os.Stat(r.To); err != nil {
+			continue
+		}
+		if err := os.Rename(r.To, r.From); err != nil {
+			return fmt.Errorf("failed to undo rename of %s: %w", r.From, err)
+		}
+	}
+	return nil
+}
+
+// swapJournaled performs the renames in order under a journal at path. If one fails, the
+// renames already made are undone.
+func swapJournaled(path string, renames []journalRename) error {
+	j := &swapJournal{Renames: renames}
+	if err := j.save(path); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	for i, r := range renames {
+		if err := os.Rename(r.From, r.To); err != nil {
+			if rollbackErr := j.rollback(); rollbackErr != nil {
+				// Keep the journal so that the next start tries again
+				return fmt.Errorf("%w, and %v", err, rollbackErr)
+			}
+			os.Remove(path)
+			return err
+		}
+
+		// A stale count is safe, rollback checks which renames were made
+		j.Done = i + 1
+		j.save(path)
+	}
+
+	return os.Remove(path)
+}
+
+// RecoverSwap finishes a directory swap of TargetDir that was interrupted by a crash.
+// A swap that completed only has its journal removed, and any other is undone, leaving
+// the previous directory in place. It should be called on startup before TargetDir is used.
+func RecoverSwap(config Config) error {
+	if config.TargetDir == "" {
+		return nil
+	}
+
+	path := config.journalPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var j swapJournal
+	if err := json.Unmarshal(data, &j); err != nil {
+		// The journal is written in full before the first rename, so nothing was swapped yet
+		config.logf("Discarding unreadable journal %s: %v", path, err)
+		return os.Remove(path)
+	}
+
+	if j.Done < len(j.Renames) {
+		config.logf("Undoing interrupted update of %s", config.TargetDir)
+		if err := j.rollback(); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(path)
+}
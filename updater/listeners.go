@@ -0,0 +1,95 @@
+// updater/listeners.go
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// listenFDsEnv tells a restarted process how many listeners it inherited
+const listenFDsEnv = "OTA_LISTEN_FDS"
+
+// firstInheritedFD is the descriptor of the first entry of exec.Cmd.ExtraFiles
+const firstInheritedFD = 3
+
+// RestartWithListeners starts executablePath with args, handing over listeners as inherited
+// file descriptors so the new process can accept connections on the same sockets. Once it
+// is running, drain (if not nil) lets the current process finish in-flight work before it exits.
+// It only returns when the new process could not be started.
+func RestartWithListeners(executablePath string, args []string, listeners []net.Listener, drain func()) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("inheriting listeners is not supported on Windows")
+	}
+
+	files := make([]*os.File, 0, len(listeners))
+	for _, listener := range listeners {
+		fileListener, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener on %s cannot be inherited", listener.Addr())
+		}
+
+		file, err := fileListener.File()
+		if err != nil {
+			return fmt.Errorf("failed to get descriptor of listener on %s: %w", listener.Addr(), err)
+		}
+		defer file.Close()
+		files = append(files, file)
+	}
+
+	// The new process must not mistake this exit for a crash
+	RecordExit(executablePath)
+
+	cmd := exec.Command(executablePath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), listenFDsEnv+"="+strconv.Itoa(len(files)))
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to restart application: %w", err)
+	}
+
+	if drain != nil {
+		drain()
+	}
+
+	os.Exit(0)
+	return nil
+}
+
+// InheritedListeners returns the listeners handed over by RestartWithListeners, in the
+// order they were passed, or nil when the process was not started that way
+func InheritedListeners() ([]net.Listener, error) {
+	value := os.Getenv(listenFDsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(listenFDsEnv)
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid %s value %q", listenFDsEnv, value)
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		file := os.NewFile(uintptr(firstInheritedFD+i), "listener-"+strconv.Itoa(i))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to inherit listener %d: %w", i, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
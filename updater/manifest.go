@@ -0,0 +1,131 @@
+// updater/manifest.go
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// manifestAssetName is the release asset describing all other assets of a release
+const manifestAssetName = "manifest.json"
+
+// maxManifestSize bounds how much of a manifest is read
+const maxManifestSize = 1 << 20
+
+// Release urgencies a manifest may declare
+const (
+	UrgencyCritical    = "critical"
+	UrgencyRecommended = "recommended"
+	UrgencyOptional    = "optional"
+)
+
+// Manifest describes the platform assets of a release
+type Manifest struct {
+	// Version is the release the manifest belongs to. It is required for signed manifests
+	// so that a signed manifest of another release cannot be replayed.
+	Version string `json:"version,omitempty"`
+	// Urgency is one of UrgencyCritical, UrgencyRecommended or UrgencyOptional
+	Urgency string `json:"urgency,omitempty"`
+	// EnforceAfter announces when the release becomes mandatory. Until then it is installed
+	// like any other, afterwards it is critical and no longer waits for the download or
+	// maintenance window or the update budget.
+	EnforceAfter time.Time       `json:"enforce_after,omitzero"`
+	Assets       []ManifestAsset `json:"assets"`
+}
+
+// ManifestAsset describes a single platform asset listed in a manifest
+type ManifestAsset struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Arch     string `json:"arch"`
+	SHA256   string `json:"sha256,omitempty"`
+	SHA512   string `json:"sha512,omitempty"`
+	BLAKE2b  string `json:"blake2b,omitempty"`
+	Size     int64  `json:"size"`
+	// MinVersion is the oldest version allowed to update directly to this release
+	MinVersion string `json:"min_version,omitempty"`
+	// Requirements the host must meet, such as {"min_kernel": "5.10", "libc": "glibc"}
+	Requirements map[string]string `json:"requirements,omitempty"`
+	// PreInstall and PostInstall are scripts run before and after the executable is replaced
+	PreInstall  *ManifestScript `json:"pre_install,omitempty"`
+	PostInstall *ManifestScript `json:"post_install,omitempty"`
+}
+
+// ManifestScript names a release asset holding an install script and its checksum
+type ManifestScript struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// find returns the manifest entry for the given platform and architecture, or on macOS
+// a universal entry when there is none for the architecture itself
+func (m *Manifest) find(platform, arch string) *ManifestAsset {
+	for i := range m.Assets {
+		if m.Assets[i].Platform == platform && m.Assets[i].Arch == arch {
+			return &m.Assets[i]
+		}
+	}
+	for i := range m.Assets {
+		if m.Assets[i].Platform == platform && isUniversalArch(platform, m.Assets[i].Arch) {
+			return &m.Assets[i]
+		}
+	}
+	return nil
+}
+
+// fetchManifest downloads and parses the release manifest, returning nil when the release has none.
+// With a public key configured the manifest must carry a valid signature for this release.
+func fetchManifest(config Config, release *github.RepositoryRelease) (*Manifest, error) {
+	asset := assetByName(release.Assets, manifestAssetName)
+	if asset == nil {
+		return nil, nil
+	}
+
+	resp, err := fetchAsset(config, asset.GetBrowserDownloadURL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Assets are served as octet-stream, so only an HTML page is a sure sign of interception
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, checkJSONResponse(resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if config.ManifestPublicKey != nil {
+		if err := verifyManifestSignature(config, release, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if config.ManifestPublicKey != nil && compareVersions(manifest.Version, release.GetTagName()) != 0 {
+		return nil, fmt.Errorf("%w: manifest is for version %q, not %s", ErrUnsignedRelease, manifest.Version, release.GetTagName())
+	}
+
+	return &manifest, nil
+}
+
+// assetByName returns the release asset with the given name
+func assetByName(assets []*github.ReleaseAsset, name string) *github.ReleaseAsset {
+	for _, asset := range assets {
+		if asset.GetName() == name && asset.BrowserDownloadURL != nil {
+			return asset
+		}
+	}
+	return nil
+}
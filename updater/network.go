@@ -0,0 +1,24 @@
+// updater/network.go
+package updater
+
+import (
+	"net"
+	"time"
+)
+
+// defaultProbeTimeout is used when no probe timeout is configured
+const defaultProbeTimeout = 5 * time.Second
+
+// Reachable reports whether a TCP connection to address ("host:port") can be opened within timeout
+func Reachable(address string, timeout time.Duration) bool {
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
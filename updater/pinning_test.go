@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// spkiPin returns the pin of a certificate's public key
+func spkiPin(cert *x509.Certificate) []byte {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return digest[:]
+}
+
+// selfSignedServer starts a TLS server presenting a freshly generated self-signed
+// certificate, which no root pool trusts
+func selfSignedServer(t *testing.T) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, cert
+}
+
+func TestTransportCertificatePins(t *testing.T) {
+	trusted := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	trusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	trusted.StartTLS()
+	defer trusted.Close()
+	untrusted, untrustedCert := selfSignedServer(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(trusted.Certificate())
+
+	tests := []struct {
+		name string
+		url  string
+		pins [][]byte
+		// want is the error expected, nil for a successful request
+		want error
+		// wantAuthority expects the chain itself to be rejected before pins are checked
+		wantAuthority bool
+	}{
+		{name: "matching pin", url: trusted.URL, pins: [][]byte{spkiPin(trusted.Certificate())}},
+		{name: "one of several pins", url: trusted.URL, pins: [][]byte{make([]byte, sha256.Size), spkiPin(trusted.Certificate())}},
+		{name: "mismatched pin", url: trusted.URL, pins: [][]byte{spkiPin(untrustedCert)}, want: ErrCertificatePin},
+		{name: "pinned leaf without a verified chain", url: untrusted.URL, pins: [][]byte{spkiPin(untrustedCert)}, wantAuthority: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{CertificatePins: tt.pins, Logger: log.New(io.Discard, "", 0), rootCAs: roots}
+			client := &http.Client{Transport: config.transport()}
+
+			resp, err := client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			var authority x509.UnknownAuthorityError
+			switch {
+			case tt.wantAuthority:
+				if !errors.As(err, &authority) {
+					t.Errorf("GET error = %v, want the unverified chain rejected", err)
+				}
+			case tt.want == nil:
+				if err != nil {
+					t.Errorf("GET error = %v, want success", err)
+				}
+			case !errors.Is(err, tt.want):
+				t.Errorf("GET error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyCertificatePinsIgnoresUnverifiedCertificates(t *testing.T) {
+	_, cert := selfSignedServer(t)
+
+	// A leaf the server sent but that did not verify leaves no chain to match the pin in
+	if err := verifyCertificatePins([][]byte{spkiPin(cert)}, nil); !errors.Is(err, ErrCertificatePin) {
+		t.Errorf("verifyCertificatePins() without a verified chain = %v, want ErrCertificatePin", err)
+	}
+	if err := verifyCertificatePins([][]byte{spkiPin(cert)}, [][]*x509.Certificate{{cert}}); err != nil {
+		t.Errorf("verifyCertificatePins() with the pinned leaf verified = %v, want success", err)
+	}
+}
@@ -0,0 +1,126 @@
+// updater/pipeline.go
+package updater
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// pipelineDepth is how many chunks may wait for a pipelined hash before writes block
+const pipelineDepth = 8
+
+// pipelinedWriter hands each write to its own goroutine, so a hash runs on another core
+// than the download or file read feeding it and than any other hash of the same data
+type pipelinedWriter struct {
+	h      hash.Hash
+	chunks chan []byte
+	free   chan []byte
+	done   chan struct{}
+}
+
+// newPipelinedWriter starts hashing in the background until wait is called
+func newPipelinedWriter(h hash.Hash) *pipelinedWriter {
+	p := &pipelinedWriter{
+		h:      h,
+		chunks: make(chan []byte, pipelineDepth),
+		// One buffer more than can be queued, processed and filled at once, so returning
+		// a buffer never blocks
+		free: make(chan []byte, pipelineDepth+2),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		for chunk := range p.chunks {
+			p.h.Write(chunk)
+			p.free <- chunk
+		}
+	}()
+	return p
+}
+
+// Write queues a copy of b, since the caller may reuse it once Write returns. Hashes never
+// fail to write.
+func (p *pipelinedWriter) Write(b []byte) (int, error) {
+	var buf []byte
+	select {
+	case buf = <-p.free:
+	default:
+	}
+	p.chunks <- append(buf[:0], b...)
+	return len(b), nil
+}
+
+// wait returns once every queued write has been hashed
+func (p *pipelinedWriter) wait() {
+	close(p.chunks)
+	<-p.done
+}
+
+// pipelineHashes reports whether data of the given size is hashed in the background,
+// which only pays off on multiple cores for large artifacts
+func (c Config) pipelineHashes(size int64) bool {
+	return c.ParallelHashSize > 0 && size >= c.ParallelHashSize
+}
+
+// hashWriters returns a writer for each hash, pipelined when data of the given size should
+// be, and a function to call before reading the sums
+func (c Config) hashWriters(size int64, hashes ...hash.Hash) ([]io.Writer, func()) {
+	writers := make([]io.Writer, len(hashes))
+	if !c.pipelineHashes(size) {
+		for i, h := range hashes {
+			writers[i] = h
+		}
+		return writers, func() {}
+	}
+
+	pipelines := make([]*pipelinedWriter, len(hashes))
+	for i, h := range hashes {
+		pipelines[i] = newPipelinedWriter(h)
+		writers[i] = pipelines[i]
+	}
+	return writers, func() {
+		for _, p := range pipelines {
+			p.wait()
+		}
+	}
+}
+
+// hashFileAll returns the hex digests of the file at path for each algorithm, reading the
+// file once
+func (c Config) hashFileAll(path string, algorithms ...string) ([]string, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	for i, algorithm := range algorithms {
+		h, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	writers, wait := c.hashWriters(info.Size(), hashes...)
+	_, err = io.Copy(io.MultiWriter(writers...), file)
+	wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	digests := make([]string, len(hashes))
+	for i, h := range hashes {
+		digests[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, nil
+}
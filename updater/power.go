@@ -0,0 +1,113 @@
+// updater/power.go
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// powerStatus describes how the host is powered
+type powerStatus struct {
+	onBattery bool
+	// percent is the remaining battery charge
+	percent int
+}
+
+// batteryPercent matches the charge in the output of pmset
+var batteryPercent = regexp.MustCompile(`(\d+)%`)
+
+// readPowerStatus returns the power status of the host, or nil when it has no battery or
+// the platform does not report one
+func readPowerStatus() *powerStatus {
+	switch runtime.GOOS {
+	case "linux":
+		return linuxPowerStatus()
+	case "darwin":
+		out, err := hostOutput("pmset", "-g", "batt")
+		if err != nil {
+			return nil
+		}
+		match := batteryPercent.FindSubmatch(out)
+		if match == nil {
+			return nil
+		}
+		percent, _ := strconv.Atoi(string(match[1]))
+		return &powerStatus{onBattery: strings.Contains(string(out), "'Battery Power'"), percent: percent}
+	case "windows":
+		// BatteryStatus 1 means discharging
+		out, err := hostOutput("powershell", "-NoProfile", "-Command",
+			"Get-CimInstance Win32_Battery | Select-Object -First 1 | ForEach-Object { \"$($_.BatteryStatus) $($_.EstimatedChargeRemaining)\" }")
+		if err != nil {
+			return nil
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) != 2 {
+			return nil
+		}
+		percent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil
+		}
+		return &powerStatus{onBattery: fields[0] == "1", percent: percent}
+	}
+	return nil
+}
+
+// linuxPowerStatus reads the first battery from sysfs. The host counts as on battery
+// unless a mains or USB supply is online.
+func linuxPowerStatus() *powerStatus {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+
+	var status *powerStatus
+	external := false
+	for _, supply := range supplies {
+		kind, err := os.ReadFile(filepath.Join(supply, "type"))
+		if err != nil {
+			continue
+		}
+
+		switch strings.TrimSpace(string(kind)) {
+		case "Battery":
+			if status != nil {
+				continue
+			}
+			capacity, err := os.ReadFile(filepath.Join(supply, "capacity"))
+			if err != nil {
+				continue
+			}
+			percent, err := strconv.Atoi(strings.TrimSpace(string(capacity)))
+			if err != nil {
+				continue
+			}
+			status = &powerStatus{percent: percent}
+		case "Mains", "USB":
+			online, _ := os.ReadFile(filepath.Join(supply, "online"))
+			external = external || strings.TrimSpace(string(online)) == "1"
+		}
+	}
+
+	if status != nil {
+		status.onBattery = !external
+	}
+	return status
+}
+
+// lowBattery reports whether the host runs on a battery charged below MinBatteryPercent,
+// in which case updates wait for it to be charged or connected to power
+func (c Config) lowBattery(version string) bool {
+	if c.MinBatteryPercent <= 0 {
+		return false
+	}
+
+	status := readPowerStatus()
+	if status == nil || !status.onBattery || status.percent >= c.MinBatteryPercent {
+		return false
+	}
+
+	c.logf("On battery at %d%%, below %d%%, deferring update %s", status.percent, c.MinBatteryPercent, version)
+	return true
+}
@@ -0,0 +1,55 @@
+// updater/probe.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrVersionMismatch is returned when a downloaded executable reports another version
+// than the release it was published in
+var ErrVersionMismatch = errors.New("executable reports a different version than its release")
+
+// defaultVersionProbeTimeout bounds how long an executable may take to report its version by default
+const defaultVersionProbeTimeout = 10 * time.Second
+
+// reportedVersion matches version numbers in the output of a version probe
+var reportedVersion = regexp.MustCompile(`v?\d+(\.\d+)+(-[0-9A-Za-z.-]+)?`)
+
+// probeVersion runs the executable at path with VersionProbe as its arguments and checks
+// that its output names version, catching releases that were packaged with the wrong build
+func probeVersion(config Config, path, version string) error {
+	if len(config.VersionProbe) == 0 {
+		return nil
+	}
+
+	if err := os.Chmod(path, config.fileMode()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	timeout := config.versionProbeTimeout()
+	ctx, cancel := context.WithTimeout(config.baseContext(), timeout)
+	defer cancel()
+
+	out, err := command(ctx, path, config.VersionProbe...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to probe the executable of version %s: %w", version, commandError(ctx, "version probe", timeout, err))
+	}
+
+	reported := reportedVersion.FindAllString(string(out), -1)
+	for _, candidate := range reported {
+		if compareVersions(candidate, version) == 0 {
+			return nil
+		}
+	}
+
+	if len(reported) == 0 {
+		return fmt.Errorf("%w: expected %s, output has no version: %q", ErrVersionMismatch, version, strings.TrimSpace(string(out)))
+	}
+	return fmt.Errorf("%w: expected %s, got %s", ErrVersionMismatch, version, strings.Join(reported, ", "))
+}
@@ -0,0 +1,66 @@
+// updater/register.go
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Registration announces a device to a fleet service when it starts, so that it is known
+// before its first update check
+type Registration struct {
+	DeviceID string `json:"device_id"`
+	Platform string `json:"platform"`
+	Arch     string `json:"arch"`
+	Version  string `json:"version"`
+	// Targets maps the name of each additional target to its installed version
+	Targets map[string]string `json:"targets,omitempty"`
+}
+
+// NewRegistration describes the running device. The device ID defaults to the hostname.
+func NewRegistration(deviceID, version string) (Registration, error) {
+	if deviceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return Registration{}, fmt.Errorf("failed to determine device ID: %w", err)
+		}
+		deviceID = hostname
+	}
+
+	return Registration{DeviceID: deviceID, Platform: runtime.GOOS, Arch: runtime.GOARCH, Version: version}, nil
+}
+
+// Register posts the registration as JSON to url, authenticated with a bearer token when
+// one is given. Any 2xx response counts as registered.
+func Register(config Config, url, token string, registration Registration) error {
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid registration URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: config.transport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to register: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("registration failed with status code %d", resp.StatusCode)
+	}
+	return nil
+}
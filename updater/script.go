@@ -0,0 +1,165 @@
+// updater/script.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// ErrInstallScript is returned when a release's install script is not allowed, cannot be
+// verified or fails
+var ErrInstallScript = errors.New("install script failed")
+
+// defaultInstallScriptTimeout bounds how long an install script may run by default
+const defaultInstallScriptTimeout = 5 * time.Minute
+
+// maxScriptOutput bounds how much script output is quoted in errors
+const maxScriptOutput = 1024
+
+// Install script phases, passed to scripts as OTA_PHASE
+const (
+	phasePreInstall  = "pre_install"
+	phasePostInstall = "post_install"
+)
+
+// installScript is a verified release asset run before or after the executable is replaced
+type installScript struct {
+	phase  string
+	name   string
+	sha256 string
+	asset  *github.ReleaseAsset
+}
+
+// resolveScripts finds the release assets of the scripts a manifest entry declares. Releases
+// with scripts are refused unless AllowInstallScripts is set, since they may need them to work.
+func resolveScripts(config Config, release *github.RepositoryRelease, entry *ManifestAsset, selected *selectedAsset) error {
+	for _, declared := range []struct {
+		phase  string
+		script *ManifestScript
+	}{{phasePreInstall, entry.PreInstall}, {phasePostInstall, entry.PostInstall}} {
+		if declared.script == nil {
+			continue
+		}
+		if !config.AllowInstallScripts {
+			return fmt.Errorf("%w: release declares a %s script, but install scripts are not allowed", ErrInstallScript, declared.phase)
+		}
+		if declared.script.SHA256 == "" {
+			return fmt.Errorf("%w: %s script %s has no sha256", ErrInstallScript, declared.phase, declared.script.Name)
+		}
+		// The batch file replacing the executable only runs once the updater has exited
+		if declared.phase == phasePostInstall && runtime.GOOS == "windows" {
+			return fmt.Errorf("%w: %s scripts are not supported on Windows", ErrInstallScript, declared.phase)
+		}
+
+		asset := assetByName(release.Assets, declared.script.Name)
+		if asset == nil {
+			return fmt.Errorf("%w: %s script %s not in release", ErrInstallScript, declared.phase, declared.script.Name)
+		}
+
+		script := &installScript{phase: declared.phase, name: declared.script.Name, sha256: strings.ToLower(declared.script.SHA256), asset: asset}
+		if declared.phase == phasePreInstall {
+			selected.preInstall = script
+		} else {
+			selected.postInstall = script
+		}
+	}
+	return nil
+}
+
+// runScript downloads and verifies the script, then runs it in a fresh working directory
+// with only a minimal environment and ScriptTimeout to finish. A non-zero exit fails
+// the update.
+func (c Config) runScript(script *installScript, version string) error {
+	if script == nil {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(c.stagingDir(), "script_*")
+	if err != nil {
+		return fmt.Errorf("failed to create script directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path, err := c.fetchScript(script, dir)
+	if err != nil {
+		return err
+	}
+
+	timeout := c.ScriptTimeout
+	if timeout <= 0 {
+		timeout = defaultInstallScriptTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.baseContext(), timeout)
+	defer cancel()
+
+	c.logf("Running %s script %s for version %s", script.phase, script.name, version)
+
+	cmd := command(ctx, path)
+	cmd.Dir = dir
+	cmd.Env = scriptEnv(c, dir, script.phase, version)
+
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ErrInstallScript, commandError(ctx, script.phase+" script "+script.name, timeout, err))
+	}
+	if err != nil {
+		output := strings.TrimSpace(string(out))
+		if len(output) > maxScriptOutput {
+			output = output[len(output)-maxScriptOutput:]
+		}
+		return fmt.Errorf("%w: %s script %s: %v: %q", ErrInstallScript, script.phase, script.name, err, output)
+	}
+	return nil
+}
+
+// fetchScript downloads the script into dir and verifies its checksum
+func (c Config) fetchScript(script *installScript, dir string) (string, error) {
+	// Progress reports the download of the executable only
+	c.Progress = nil
+
+	tempPath, digest, err := downloadUpdate(c, script.asset.GetBrowserDownloadURL(), SHA256, false)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to download %s: %v", ErrInstallScript, script.name, err)
+	}
+	path := filepath.Join(dir, filepath.Base(script.name))
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to move script: %w", err)
+	}
+	if digest != script.sha256 {
+		return "", fmt.Errorf("%w: %s sha256 mismatch: expected %s, got %s", ErrInstallScript, script.name, script.sha256, digest)
+	}
+	if err := os.Chmod(path, 0700); err != nil {
+		return "", fmt.Errorf("failed to set script permissions: %w", err)
+	}
+	return path, nil
+}
+
+// scriptEnv returns the environment of an install script. It inherits nothing but what is
+// needed to run programs, so that credentials in the updater's environment do not leak.
+func scriptEnv(config Config, dir, phase, version string) []string {
+	env := []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"OTA_PHASE=" + phase,
+		"OTA_VERSION=" + version,
+		"OTA_PREVIOUS_VERSION=" + config.CurrentVersion,
+		"OTA_EXECUTABLE=" + config.ExecutablePath,
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, name := range []string{"SystemRoot", "ComSpec", "PATHEXT", "PATH"} {
+			env = append(env, name+"="+os.Getenv(name))
+		}
+		return append(env, "TEMP="+dir, "TMP="+dir)
+	}
+	return append(env, "PATH=/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin")
+}
@@ -0,0 +1,36 @@
+// updater/sidecar.go
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// readyMarkerExt is appended to the installed path to form the ready marker
+const readyMarkerExt = ".ready"
+
+// writeReadyMarker records the installed version next to the executable, or the target
+// directory for source archives, when ReadyMarker is set. A supervisor watching for the
+// marker restarts the application, which is how updates complete in a sidecar deployment.
+func writeReadyMarker(config Config, version string) error {
+	if !config.ReadyMarker {
+		return nil
+	}
+
+	path := normalizeExecutablePath(config.ExecutablePath)
+	if config.SourceArchive != "" {
+		path = filepath.Clean(config.TargetDir)
+	}
+	path += readyMarkerExt
+
+	// Written to a temporary file first so a watcher never reads a partial version
+	if err := os.WriteFile(path+".tmp", []byte(version+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write ready marker: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write ready marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// updater/signature.go
+package updater
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// manifestSignatureName is the release asset holding the detached manifest signature
+const manifestSignatureName = manifestAssetName + ".sig"
+
+// ErrUnsignedRelease is returned when a public key is configured but the release
+// metadata is not signed by it
+var ErrUnsignedRelease = errors.New("release metadata is not signed by the configured key")
+
+// ParsePublicKey decodes a base64 encoded ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// SignManifest writes the detached signature of the manifest at path to path + ".sig",
+// signing with the ed25519 key derived from a base64 encoded 32 byte seed. It returns
+// the matching public key to configure on clients.
+func SignManifest(path, seed string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing key: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes, got %d", ed25519.SeedSize, len(raw))
+	}
+	key := ed25519.NewKeyFromSeed(raw)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := os.WriteFile(path+".sig", []byte(signature+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// verifyManifestSignature checks the manifest contents against the release's detached signature
+func verifyManifestSignature(config Config, release *github.RepositoryRelease, data []byte) error {
+	asset := assetByName(release.Assets, manifestSignatureName)
+	if asset == nil {
+		return fmt.Errorf("%w: release %s has no %s", ErrUnsignedRelease, release.GetTagName(), manifestSignatureName)
+	}
+
+	resp, err := fetchAsset(config, asset.GetBrowserDownloadURL())
+	if err != nil {
+		return fmt.Errorf("failed to download manifest signature: %w", err)
+	}
+	defer resp.Body.Close()
+
+	signature, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumFileSize))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest signature: %w", err)
+	}
+
+	return verifySignature(config.ManifestPublicKey, data, signature)
+}
+
+// verifySignature checks a detached manifest signature, given either raw or base64 encoded
+func verifySignature(key ed25519.PublicKey, data, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		var err error
+		if signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err != nil {
+			return fmt.Errorf("%w: malformed signature: %v", ErrUnsignedRelease, err)
+		}
+	}
+
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("%w: signature of %s does not match", ErrUnsignedRelease, manifestAssetName)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// updater/space.go
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// ErrInsufficientSpace is returned when the disk runs out of space or inodes while an update
+// is downloaded, staged or backed up. The executable is never touched when this happens.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// Windows error codes for a full disk, ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// noSpace marks err with ErrInsufficientSpace when it reports a full disk, an exhausted
+// inode table or an exceeded quota, and returns any other error unchanged
+func noSpace(err error) error {
+	if err == nil || errors.Is(err, ErrInsufficientSpace) {
+		return err
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	full := errno == syscall.ENOSPC || errno == syscall.EDQUOT
+	if runtime.GOOS == "windows" {
+		full = errno == errorHandleDiskFull || errno == errorDiskFull
+	}
+	if !full {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrInsufficientSpace, err)
+}
+
+// checkFreeSpace returns ErrInsufficientSpace when the staging directory has less than size
+// bytes or no inode free, so a download that cannot fit fails before it starts rather than
+// once it filled the disk. An unknown size only has the inodes checked, and free space that
+// cannot be determined lets the download start.
+func (c Config) checkFreeSpace(size int64) error {
+	dir := c.stagingDir()
+	free, inodes, err := diskFree(dir)
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			c.logf("Failed to determine free space in %s: %v", dir, err)
+		}
+		return nil
+	}
+	return roomFor(dir, size, free, inodes)
+}
+
+// roomFor returns ErrInsufficientSpace unless free bytes and inodes in dir leave room for a
+// file of size bytes, or of any size when size is unknown
+func roomFor(dir string, size int64, free, inodes uint64) error {
+	if inodes == 0 {
+		return fmt.Errorf("%w: %s has no inodes left", ErrInsufficientSpace, dir)
+	}
+	if size > 0 && free < uint64(size) {
+		return fmt.Errorf("%w: %s has %d bytes free, the download needs %d", ErrInsufficientSpace, dir, free, size)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+// updater/space_other.go
+package updater
+
+import "errors"
+
+// diskFree is not supported on this platform, downloads only fail once the disk is full
+func diskFree(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
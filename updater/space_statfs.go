@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+// updater/space_statfs.go
+package updater
+
+import (
+	"math"
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users and the free inodes on the file
+// system holding dir. File systems without a fixed inode table, such as btrfs, report none
+// in total, so their free inodes are unlimited.
+func diskFree(dir string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+
+	inodes := uint64(math.MaxUint64)
+	if st.Files > 0 {
+		inodes = uint64(st.Ffree)
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), inodes, nil
+}
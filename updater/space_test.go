@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"testing"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	config := Config{StagingDir: t.TempDir(), Logger: log.New(io.Discard, "", 0)}
+	if _, _, err := diskFree(config.StagingDir); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space cannot be determined on this platform")
+	}
+
+	tests := []struct {
+		name string
+		size int64
+		want error
+	}{
+		{name: "unknown size", size: -1},
+		{name: "fits", size: 1},
+		{name: "larger than any disk", size: math.MaxInt64, want: ErrInsufficientSpace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := config.checkFreeSpace(tt.size); !errors.Is(err, tt.want) {
+				t.Errorf("checkFreeSpace(%d) = %v, want %v", tt.size, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomFor(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int64
+		free, inodes uint64
+		want         error
+	}{
+		{name: "fits", size: 100, free: 100, inodes: 1},
+		{name: "too large", size: 101, free: 100, inodes: 1, want: ErrInsufficientSpace},
+		{name: "no inodes", size: 1, free: 100, inodes: 0, want: ErrInsufficientSpace},
+		{name: "unknown size", size: -1, free: 0, inodes: 1},
+		{name: "unknown size without inodes", size: -1, free: 100, inodes: 0, want: ErrInsufficientSpace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := roomFor("staging", tt.size, tt.free, tt.inodes); !errors.Is(err, tt.want) {
+				t.Errorf("roomFor(%d, %d, %d) = %v, want %v", tt.size, tt.free, tt.inodes, err, tt.want)
+			}
+		})
+	}
+}
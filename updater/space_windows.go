@@ -0,0 +1,24 @@
+//go:build windows
+
+// updater/space_windows.go
+package updater
+
+import (
+	"math"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskFree returns the bytes available to the current user on the volume holding dir. NTFS
+// has no inode table to run out of, so free inodes are unlimited.
+func diskFree(dir string) (uint64, uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return 0, 0, err
+	}
+	return free, math.MaxUint64, nil
+}
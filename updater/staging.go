@@ -0,0 +1,103 @@
+// updater/staging.go
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stagingDir returns the directory downloads are written to
+func (c Config) stagingDir() string {
+	if c.StagingDir != "" {
+		return c.StagingDir
+	}
+	return os.TempDir()
+}
+
+// stagedPath returns where a verified download of version waits to be applied
+func (c Config) stagedPath(version string) string {
+	return filepath.Join(c.stagingDir(), filepath.Base(c.ExecutablePath)+"-"+version+".staged")
+}
+
+// stageUpdate moves a verified download to its staged path and returns that path.
+// Updates staged for other versions are superseded and removed.
+func stageUpdate(config Config, tempPath, version string) (string, error) {
+	stagedPath := config.stagedPath(version)
+	if err := os.Rename(tempPath, stagedPath); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to stage update: %w", err)
+	}
+
+	others, _ := filepath.Glob(filepath.Join(config.stagingDir(), filepath.Base(config.ExecutablePath)+"-*.staged"))
+	for _, other := range others {
+		if other != stagedPath {
+			config.logf("Removing superseded staged update %s", other)
+			os.Remove(other)
+		}
+	}
+	return stagedPath, nil
+}
+
+// deferDownload returns a deferred result while the download window is closed or the
+// battery is low, or nil when version may be downloaded now
+func deferDownload(config Config, version string, selected *selectedAsset) (*Result, error) {
+	if config.lowBattery(version) {
+		return &Result{Version: version, Deferred: true, Urgency: selected.urgency, EnforceAfter: selected.enforceAfter}, nil
+	}
+	if config.DownloadWindow == nil || selected.enforced(config) {
+		return nil, nil
+	}
+
+	now := config.now()
+	open, err := config.DownloadWindow.Contains(now)
+	if err != nil || open {
+		return nil, err
+	}
+
+	downloadAt, _ := config.DownloadWindow.Next(now)
+	config.logf("Update %s available, deferring download until %s", version, downloadAt.Format(time.RFC3339))
+	return &Result{Version: version, Deferred: true, DownloadAt: downloadAt, Urgency: selected.urgency, EnforceAfter: selected.enforceAfter}, nil
+}
+
+// stagedUpdate returns the path of a previously staged download of version when it still
+// matches the expected checksum, or without one the declared size, so a retried apply does
+// not download it again. An empty path means there is nothing usable staged.
+func stagedUpdate(config Config, version string, selected *selectedAsset, timings *Timings) string {
+	stagedPath := config.stagedPath(version)
+	info, err := os.Stat(stagedPath)
+	if err != nil {
+		return ""
+	}
+
+	if !selected.verifiable() {
+		config.logf("Discarding staged update %s: there is no checksum or size to verify it", stagedPath)
+		os.Remove(stagedPath)
+		return ""
+	}
+	// A size alone does not tell a re-uploaded asset apart, its upload time does
+	if uploaded := selected.asset.GetUpdatedAt(); selected.checksum == "" && info.ModTime().Before(uploaded.Time) {
+		config.logf("Discarding staged update %s: the asset was replaced since it was staged", stagedPath)
+		os.Remove(stagedPath)
+		return ""
+	}
+
+	verifyStart := time.Now()
+	err = verifyDownload(config, stagedPath, selected, "")
+	timings.Verify = time.Since(verifyStart)
+	if err != nil {
+		config.logf("Discarding staged update %s: %v", stagedPath, err)
+		os.Remove(stagedPath)
+		return ""
+	}
+
+	config.logf("Using staged update %s", stagedPath)
+	return stagedPath
+}
+
+// verifiable reports whether a staged download of the asset can be verified before it is
+// reused, against its checksum or at least the size the release declares
+func (s *selectedAsset) verifiable() bool {
+	return s.checksum != "" || s.size > 0
+}
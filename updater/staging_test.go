@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+func TestStagedUpdateWithoutChecksum(t *testing.T) {
+	staged := time.Now()
+
+	tests := []struct {
+		name     string
+		size     int64
+		uploaded time.Time
+		reused   bool
+	}{
+		{name: "matching size", size: 12, uploaded: staged.Add(-time.Hour), reused: true},
+		{name: "size mismatch", size: 13, uploaded: staged.Add(-time.Hour)},
+		{name: "unknown size", uploaded: staged.Add(-time.Hour)},
+		{name: "asset replaced after staging", size: 12, uploaded: staged.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := Config{
+				ExecutablePath: filepath.Join(dir, "app"),
+				StagingDir:     dir,
+				Logger:         log.New(io.Discard, "", 0),
+			}
+			writeFile(t, config.stagedPath("1.1.0"), "binary 1.1.0")
+
+			selected := &selectedAsset{
+				asset: &github.ReleaseAsset{UpdatedAt: &github.Timestamp{Time: tt.uploaded}},
+				size:  tt.size,
+			}
+			path := stagedUpdate(config, "1.1.0", selected, &Timings{})
+
+			if tt.reused {
+				if path != config.stagedPath("1.1.0") {
+					t.Errorf("stagedUpdate() = %q, want the staged file reused", path)
+				}
+				assertFile(t, config.stagedPath("1.1.0"), "binary 1.1.0")
+			} else {
+				if path != "" {
+					t.Errorf("stagedUpdate() = %q, want the staged file discarded", path)
+				}
+				assertMissing(t, config.stagedPath("1.1.0"))
+			}
+		})
+	}
+}
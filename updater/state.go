@@ -0,0 +1,157 @@
+// updater/state.go
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// State is persisted next to an executable to track whether its installed version is healthy
+type State struct {
+	// LastKnownGood is the most recent version that ran through the crash window
+	LastKnownGood string `json:"last_known_good,omitempty"`
+	// Pending is the installed version that has not yet proven itself
+	Pending     string    `json:"pending,omitempty"`
+	InstalledAt time.Time `json:"installed_at,omitzero"`
+	// Running is set while the executable runs and cleared when it exits cleanly,
+	// so finding it set on startup means the previous run crashed
+	Running bool        `json:"running,omitempty"`
+	Crashes []time.Time `json:"crashes,omitempty"`
+	// StartedAt is when the executable last started, which the restart cooldown counts from
+	StartedAt time.Time `json:"started_at,omitzero"`
+	// Reverted lists versions that were rolled back after crashing and are not offered again
+	Reverted []string `json:"reverted,omitempty"`
+	// MissingAsset is the latest version found without an asset for this platform
+	MissingAsset string `json:"missing_asset,omitempty"`
+	// Installs are the times of the most recent updates, counted against the update budget
+	Installs []time.Time `json:"installs,omitempty"`
+	// Failure is the last version that failed to install, while it is being backed off
+	Failure *FailedUpdate `json:"failure,omitempty"`
+}
+
+// statePath returns the path of the state file of an executable
+func statePath(executablePath string) string {
+	return normalizeExecutablePath(executablePath) + ".state.json"
+}
+
+// LoadState reads the state of an executable, returning an empty state when there is none
+func LoadState(executablePath string) (*State, error) {
+	var state State
+	data, err := os.ReadFile(statePath(executablePath))
+	if os.IsNotExist(err) {
+		return &state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+	return &state, nil
+}
+
+// save writes the state of an executable, replacing the previous file atomically
+func (s *State) save(executablePath string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := statePath(executablePath)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// isReverted reports whether version was rolled back after crashing
+func (s *State) isReverted(version string) bool {
+	for _, reverted := range s.Reverted {
+		if compareVersions(reverted, version) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// updateState loads the state of an executable, applies fn and saves the result
+func updateState(executablePath string, fn func(*State)) error {
+	state, err := LoadState(executablePath)
+	if err != nil {
+		return err
+	}
+	fn(state)
+	return state.save(executablePath)
+}
+
+// recordInstall marks version as pending after it replaced config.CurrentVersion, which
+// becomes the last known good version unless it was itself still pending. The install is
+// added to the history by the clock the update budget and restart cooldown are checked with.
+func recordInstall(config Config, version string) error {
+	return updateState(config.ExecutablePath, func(s *State) {
+		if s.Pending != config.CurrentVersion {
+			s.LastKnownGood = config.CurrentVersion
+		}
+		s.Pending = version
+		s.InstalledAt = time.Now()
+		s.Crashes = nil
+		s.Failure = nil
+		s.Installs = append(s.Installs, config.now())
+		if len(s.Installs) > maxInstallHistory {
+			s.Installs = s.Installs[len(s.Installs)-maxInstallHistory:]
+		}
+	})
+}
+
+// RecordStart is called when the executable starts running version. If the previous run
+// of a pending version crashed, the crash is counted, and once limit crashes happened
+// within window of the install, the last known good version is restored from its backup.
+// It returns the version rolled back to, in which case the caller should restart.
+func RecordStart(executablePath, version string, limit int, window time.Duration) (string, error) {
+	state, err := LoadState(executablePath)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if state.Running && state.Pending == version && now.Sub(state.InstalledAt) < window {
+		state.Crashes = append(state.Crashes, now)
+	}
+	state.Running = true
+	state.StartedAt = now
+
+	if limit > 0 && len(state.Crashes) >= limit && state.LastKnownGood != "" {
+		if err := RollbackToVersion(executablePath, state.LastKnownGood); err != nil {
+			state.save(executablePath)
+			return "", fmt.Errorf("version %s crashed %d times, failed to revert to %s: %w", version, len(state.Crashes), state.LastKnownGood, err)
+		}
+
+		state.Reverted = append(state.Reverted, version)
+		state.Pending, state.InstalledAt, state.Crashes = "", time.Time{}, nil
+		state.Running = false
+		return state.LastKnownGood, state.save(executablePath)
+	}
+
+	return "", state.save(executablePath)
+}
+
+// RecordExit marks a clean exit, so the next start is not counted as a crash
+func RecordExit(executablePath string) error {
+	return updateState(executablePath, func(s *State) {
+		s.Running = false
+	})
+}
+
+// ConfirmVersion marks version as known good once it ran through the crash window
+func ConfirmVersion(executablePath, version string) error {
+	return updateState(executablePath, func(s *State) {
+		if s.Pending != version {
+			return
+		}
+		s.LastKnownGood = version
+		s.Pending, s.InstalledAt, s.Crashes = "", time.Time{}, nil
+	})
+}
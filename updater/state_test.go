@@ -0,0 +1,65 @@
+package updater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordStartRollsBackCrashingUpdate(t *testing.T) {
+	f := newPipelineFixture(t)
+	if _, err := CheckAndUpdate(f.config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first start is not a crash, each further one without a clean exit is
+	for start := 1; start <= 3; start++ {
+		reverted, err := RecordStart(f.exe, "1.1.0", 2, time.Hour)
+		if err != nil {
+			t.Fatalf("RecordStart() start %d: %v", start, err)
+		}
+		if start < 3 && reverted != "" {
+			t.Fatalf("RecordStart() start %d reverted to %s, want no rollback yet", start, reverted)
+		}
+		if start == 3 && reverted != "1.0.0" {
+			t.Fatalf("RecordStart() start %d = %q, want a rollback to 1.0.0", start, reverted)
+		}
+	}
+	assertFile(t, f.exe, "binary 1.0.0")
+
+	// The crashing version is not installed again
+	result, err := CheckAndUpdate(f.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated {
+		t.Errorf("CheckAndUpdate() after the rollback = %+v, want the reverted version skipped", result)
+	}
+	assertFile(t, f.exe, "binary 1.0.0")
+}
+
+func TestRecordStartIgnoresCrashOutsideWindow(t *testing.T) {
+	f := newPipelineFixture(t)
+	if _, err := CheckAndUpdate(f.config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The window of a nanosecond is over before the first start, so no unclean exit counts
+	for start := 1; start <= 3; start++ {
+		reverted, err := RecordStart(f.exe, "1.1.0", 1, time.Nanosecond)
+		if err != nil {
+			t.Fatalf("RecordStart() start %d: %v", start, err)
+		}
+		if reverted != "" {
+			t.Fatalf("RecordStart() start %d reverted to %s, want no rollback", start, reverted)
+		}
+	}
+
+	state, err := LoadState(f.exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Crashes) != 0 {
+		t.Errorf("%d crashes recorded, want none outside the crash window", len(state.Crashes))
+	}
+	assertFile(t, f.exe, "binary 1.1.0")
+}
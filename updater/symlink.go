@@ -0,0 +1,75 @@
+// updater/symlink.go
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Ways of updating an executable that is a symbolic link
+const (
+	// SymlinkTarget replaces the file the link points to, keeping the link as it is
+	SymlinkTarget = "target"
+	// SymlinkRepoint installs the update as a new file next to the link's target, named
+	// after the link and the version, and points the link at it
+	SymlinkRepoint = "repoint"
+)
+
+// isSymlink reports whether path itself is a symbolic link
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// resolveLink returns the file path ultimately points to, or path when it is not a link.
+// Renaming onto a link would replace the link itself with a regular file.
+func resolveLink(path string) string {
+	if !isSymlink(path) {
+		return path
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
+
+// repointSymlink moves the downloaded file next to the current target of link as
+// "<link name>-<version>" and atomically points link at it. The link keeps pointing to
+// the same directory in the same relative or absolute form, and the previous target is
+// left in place.
+func repointSymlink(link, tempPath, version string) error {
+	current, err := os.Readlink(link)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink: %w", err)
+	}
+
+	dest := filepath.Join(filepath.Dir(current), filepath.Base(link)+"-"+version)
+	destPath := dest
+	if !filepath.IsAbs(dest) {
+		destPath = filepath.Join(filepath.Dir(link), dest)
+	}
+
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("failed to install %s: %w", destPath, err)
+	}
+	// A reinstall replaced the file the link already points to
+	if filepath.Clean(dest) == filepath.Clean(current) {
+		return nil
+	}
+
+	// Renaming a new link over the old one never leaves the path missing
+	tmpLink := link + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(dest, tmpLink); err != nil {
+		os.Rename(destPath, tempPath)
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+	if err := os.Rename(tmpLink, link); err != nil {
+		os.Remove(tmpLink)
+		os.Rename(destPath, tempPath)
+		return fmt.Errorf("failed to repoint symlink: %w", err)
+	}
+	return nil
+}
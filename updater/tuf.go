@@ -0,0 +1,246 @@
+// updater/tuf.go
+package updater
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	tuf "github.com/theupdateframework/go-tuf/client"
+	filejsonstore "github.com/theupdateframework/go-tuf/client/filejsonstore"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// TUFConfig resolves updates through The Update Framework instead of GitHub releases.
+// Signed root, timestamp, snapshot and targets metadata protect against forged,
+// replayed and rolled back releases as well as the compromise of a single key.
+type TUFConfig struct {
+	// RepositoryURL serves metadata at "<url>/<role>.json" and targets at "<url>/targets/<path>"
+	RepositoryURL string
+	// RootPath is the trusted root.json distributed with the application. It is only read
+	// on first use; later roots are fetched and verified through it.
+	RootPath string
+	// MetadataDir keeps verified metadata between checks, "<executable>.tuf" by default
+	MetadataDir string
+}
+
+// tufTargetCustom is the custom metadata each target must carry
+type tufTargetCustom struct {
+	Version      string    `json:"version"`
+	Platform     string    `json:"platform"`
+	Arch         string    `json:"arch"`
+	Urgency      string    `json:"urgency,omitempty"`
+	EnforceAfter time.Time `json:"enforce_after,omitzero"`
+}
+
+// tufTarget is a target built for the running platform and architecture
+type tufTarget struct {
+	name         string
+	version      string
+	urgency      string
+	enforceAfter time.Time
+	meta         data.TargetFileMeta
+}
+
+// newTUFClient returns a client for the configured repository, initializing its local
+// metadata store from the trusted root on first use
+func newTUFClient(config Config) (*tuf.Client, error) {
+	dir := config.TUF.MetadataDir
+	if dir == "" {
+		dir = normalizeExecutablePath(config.ExecutablePath) + ".tuf"
+	}
+
+	local, err := filejsonstore.NewFileJSONStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TUF metadata: %w", err)
+	}
+
+	remote, err := tuf.HTTPRemoteStore(config.TUF.RepositoryURL, nil, &http.Client{Transport: &idleTimeoutTransport{base: config.transport()}})
+	if err != nil {
+		return nil, fmt.Errorf("invalid TUF repository: %w", err)
+	}
+
+	c := tuf.NewClient(local, remote)
+
+	meta, err := local.GetMeta()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TUF metadata: %w", err)
+	}
+	if _, ok := meta["root.json"]; !ok {
+		root, err := os.ReadFile(config.TUF.RootPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TUF root: %w", err)
+		}
+		if err := c.Init(root); err != nil {
+			return nil, fmt.Errorf("failed to initialize TUF root: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// checkAndUpdateTUF performs the update check against a TUF repository
+func checkAndUpdateTUF(config Config, timings *Timings) (*Result, error) {
+	c, err := newTUFClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	checkStart := time.Now()
+	target, err := latestTUFTarget(config, c)
+	timings.Check = time.Since(checkStart)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return &Result{Version: config.CurrentVersion}, nil
+	}
+
+	config.logf("Update available: %s", target.version)
+
+	if retryAt := config.failureRetryAt(target.version); !retryAt.IsZero() {
+		config.logf("Version %s failed to install, not retrying it before %s", target.version, retryAt.Format(time.RFC3339))
+		return &Result{Version: config.CurrentVersion}, nil
+	}
+
+	result, err := installTUFTarget(config, c, target, timings)
+	if countsAsFailure(err) {
+		config.recordFailure(target.version)
+	}
+	return result, err
+}
+
+// installTUFTarget downloads target through the TUF client, which verifies it, and installs it
+func installTUFTarget(config Config, c *tuf.Client, target *tufTarget, timings *Timings) (*Result, error) {
+	selected := &selectedAsset{name: target.name, size: target.meta.Length, urgency: target.urgency, enforceAfter: target.enforceAfter}
+	selected.source = strings.TrimSuffix(config.TUF.RepositoryURL, "/") + "/targets/" + target.name
+	selected.enforce(config)
+	for _, algorithm := range []string{SHA256, SHA512} {
+		if digest, ok := target.meta.Hashes[algorithm]; ok {
+			selected.algorithm, selected.checksum = algorithm, hex.EncodeToString(digest)
+			break
+		}
+	}
+
+	config.ExecutablePath = normalizeExecutablePath(config.ExecutablePath)
+	// Targets are signed by the repository's metadata, they carry no Sigstore bundles
+	config.Cosign = nil
+
+	tempPath := stagedUpdate(config, target.version, selected, timings)
+	if tempPath == "" {
+		if result, err := deferDownload(config, target.version, selected); result != nil || err != nil {
+			return result, err
+		}
+
+		tempFile, err := os.CreateTemp(config.stagingDir(), "update_*.bin")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tempPath = tempFile.Name()
+
+		// The client verifies length and hashes against the signed targets metadata
+		downloadStart := time.Now()
+		err = c.Download(target.name, &tufDestination{tempFile})
+		tempFile.Close()
+		timings.Download = time.Since(downloadStart)
+		if err != nil {
+			os.Remove(tempPath)
+			return nil, fmt.Errorf("failed to download update: %w", err)
+		}
+	}
+
+	return installUpdate(config, target.version, selected, tempPath, timings)
+}
+
+// latestTUFTarget refreshes the metadata and returns the target to install, or nil when
+// the running version is current. Targets are matched on the version, platform and arch
+// of their custom metadata.
+func latestTUFTarget(config Config, c *tuf.Client) (*tufTarget, error) {
+	if _, err := c.Update(); err != nil {
+		return nil, fmt.Errorf("failed to update TUF metadata: %w", err)
+	}
+
+	targets, err := c.Targets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TUF targets: %w", err)
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	state, err := LoadState(config.ExecutablePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := strings.TrimPrefix(config.PinnedVersion, "v")
+	var latest *tufTarget
+	for _, name := range names {
+		meta := targets[name]
+		if meta.Custom == nil {
+			continue
+		}
+
+		var custom tufTargetCustom
+		if err := json.Unmarshal(*meta.Custom, &custom); err != nil {
+			continue
+		}
+		if custom.Platform != runtime.GOOS || custom.Arch != runtime.GOARCH {
+			continue
+		}
+
+		version := strings.TrimPrefix(custom.Version, "v")
+		if config.IncludePrerelease != nil && !*config.IncludePrerelease && IsPrerelease(version) {
+			continue
+		}
+		switch {
+		case pinned != "" && compareVersions(version, pinned) != 0:
+			continue
+		case pinned == "" && (skipEntry(version, config.SkipVersions) != "" || state.isReverted(version)):
+			continue
+		}
+
+		if latest == nil || compareVersions(version, latest.version) > 0 {
+			latest = &tufTarget{name: name, version: version, urgency: custom.Urgency, enforceAfter: custom.EnforceAfter, meta: meta}
+		}
+	}
+
+	if latest == nil {
+		if pinned != "" {
+			return nil, fmt.Errorf("%w: no TUF target for version %s on %s/%s", ErrNoAsset, pinned, runtime.GOOS, runtime.GOARCH)
+		}
+		return nil, fmt.Errorf("%w: no TUF target for %s/%s", ErrNoAsset, runtime.GOOS, runtime.GOARCH)
+	}
+
+	switch cmp := compareVersions(latest.version, config.CurrentVersion); {
+	case cmp == 0:
+		return nil, nil
+	case cmp < 0 && !config.AllowDowngrade:
+		if pinned != "" {
+			return nil, fmt.Errorf("pinned version %s is older than current version %s and downgrades are not allowed", pinned, config.CurrentVersion)
+		}
+		return nil, nil
+	}
+
+	return latest, nil
+}
+
+// tufDestination lets the TUF client write a download to a temporary file
+type tufDestination struct {
+	*os.File
+}
+
+// Delete is called by the client when the download failed verification
+func (d *tufDestination) Delete() error {
+	d.Close()
+	return os.Remove(d.Name())
+}
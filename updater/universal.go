@@ -0,0 +1,93 @@
+// updater/universal.go
+package updater
+
+import (
+	"debug/macho"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// universalArches are the names of macOS universal binaries, which contain a slice for
+// each architecture and so run on any Mac
+var universalArches = []string{"universal", "all"}
+
+// machoCPUs maps GOARCH to the CPU type of its Mach-O slice
+var machoCPUs = map[string]macho.Cpu{
+	"amd64": macho.CpuAmd64,
+	"arm64": macho.CpuArm64,
+}
+
+// isUniversalArch reports whether arch names a universal binary, which only macOS has
+func isUniversalArch(platform, arch string) bool {
+	if platform != "darwin" {
+		return false
+	}
+	for _, universal := range universalArches {
+		if strings.EqualFold(arch, universal) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUniversalAsset reports whether an asset was picked as a universal binary because it
+// names no architecture of its own
+func isUniversalAsset(name string) bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	tokens := assetTokens(strings.ToLower(name))
+	return indexOfAny(tokens, archAliases(runtime.GOARCH, goarm())) < 0 && indexOfAny(tokens, universalArches) >= 0
+}
+
+// checkUniversal verifies that the universal binary at path contains a slice for the
+// running architecture, since a universal asset's name alone does not guarantee it
+func checkUniversal(path string, selected *selectedAsset) error {
+	if !selected.universal {
+		return nil
+	}
+
+	cpu, ok := machoCPUs[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("%w: no Mach-O CPU type known for %s", ErrIncompatible, runtime.GOARCH)
+	}
+
+	fat, err := macho.OpenFat(path)
+	if errors.Is(err, macho.ErrNotFat) {
+		// A thin binary published as universal still runs if it was built for this CPU
+		thin, err := macho.Open(path)
+		if err != nil {
+			return fmt.Errorf("%w: %s is not a Mach-O binary: %v", ErrIncompatible, selected.name, err)
+		}
+		defer thin.Close()
+		if thin.Cpu != cpu {
+			return fmt.Errorf("%w: %s is built for %s only, not %s", ErrIncompatible, selected.name, cpuName(thin.Cpu), runtime.GOARCH)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s is not a Mach-O binary: %v", ErrIncompatible, selected.name, err)
+	}
+	defer fat.Close()
+
+	var slices []string
+	for _, arch := range fat.Arches {
+		if arch.Cpu == cpu {
+			return nil
+		}
+		slices = append(slices, cpuName(arch.Cpu))
+	}
+	return fmt.Errorf("%w: universal binary %s has no %s slice, only %s", ErrIncompatible, selected.name, runtime.GOARCH, strings.Join(slices, ", "))
+}
+
+// cpuName returns the GOARCH of a Mach-O CPU type, or the type itself when Go has none
+func cpuName(cpu macho.Cpu) string {
+	for goarch, c := range machoCPUs {
+		if c == cpu {
+			return goarch
+		}
+	}
+	return cpu.String()
+}
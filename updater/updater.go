@@ -2,8 +2,15 @@
 package updater
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net/http"
@@ -11,154 +18,901 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v40/github"
-	"golang.org/x/oauth2"
+	"github.com/sigstore/sigstore-go/pkg/verify"
+	"golang.org/x/time/rate"
 )
 
 // Config contains the config for the updater
 type Config struct {
+	Name           string
 	CurrentVersion string
 	GithubRepo     string
 	GithubToken    string
 	ExecutablePath string
+	// RestartArgs are the arguments the application is started with after a Windows replacement
+	RestartArgs []string
+	// AllowInstallScripts runs the pre-install and post-install scripts a release manifest
+	// declares. Releases declaring scripts are refused without it.
+	AllowInstallScripts bool
+	// ScriptTimeout bounds how long an install script may run, defaults to 5 minutes
+	ScriptTimeout time.Duration
+	// VersionProbeTimeout bounds how long a version probe may run, defaults to 10 seconds
+	VersionProbeTimeout time.Duration
+	// Context cancels running version probes and install scripts, which are then killed
+	// together with the processes they started, and the wait of ApplyGracePeriod, after
+	// which the update is not applied. It is also passed to Verifiers. Defaults to a
+	// context that is never cancelled.
+	Context context.Context
+	// Debug logs every HTTP request and response, with credentials redacted
+	Debug bool
+	// Logger receives all messages of the package, defaults to the standard logger
+	Logger *log.Logger
+	// ParallelHashSize, when set, hashes downloads and files of at least this many bytes
+	// on separate goroutines, overlapping the download or file read and every checksum
+	// computed over it. Smaller ones are hashed inline, as are all when it is zero.
+	ParallelHashSize int64
+	// DownloadRateLimit caps download speed in bytes per second, zero means unlimited
+	DownloadRateLimit int64
+	// MinDownloadRate, when set, aborts a download with ErrStalled once it receives fewer
+	// bytes per second than this over a whole StallPeriod. It must stay below DownloadRateLimit.
+	MinDownloadRate int64
+	// StallPeriod is how long a download may stay below MinDownloadRate, defaults to 30 seconds
+	StallPeriod time.Duration
+	// PinnedVersion, when set, installs exactly this version instead of tracking the latest release
+	PinnedVersion string
+	// AllowDowngrade permits installing a version older than CurrentVersion
+	AllowDowngrade bool
+	// AssetVersionPattern extracts the version from asset names such as "app-1.4.2-linux-amd64"
+	// for releases whose tag is missing or not a version, e.g. "app-(?P<version>[0-9.]+)-"
+	AssetVersionPattern string
+	// ForceReinstall installs the latest release even when it is the running version
+	ForceReinstall bool
+	// RepairCorrupted reinstalls the running version when the executable no longer matches
+	// the checksum its release publishes, for example after disk corruption
+	RepairCorrupted bool
+	// FallbackRepos are searched in order for an asset of the same version when the
+	// release in GithubRepo has none for the running platform
+	FallbackRepos []string
+	// SkipVersions lists versions that are never installed, either exact ("1.4.0")
+	// or as constraints ("<1.2.0", ">=2.0.0-rc.1")
+	SkipVersions []string
+	// Progress, when set, is called as the update downloads with the bytes received so far
+	// and the total size, which is -1 when unknown
+	Progress func(done, total int64)
+	// BackupCount is the number of previous executables kept for rollback, defaults to 1
+	BackupCount int
+	// DisableBackup skips backing up the executable before replacing it, for devices without
+	// room for a second copy. A failed update can then not be rolled back.
+	DisableBackup bool
+	// MaintenanceWindow, when set, defers installing a downloaded update until the window opens
+	MaintenanceWindow *Window
+	// MinBatteryPercent, when set, defers downloading and applying updates while the host runs
+	// on a battery charged below this percentage. Hosts without a battery are not affected.
+	MinBatteryPercent int
+	// ClockSkewTolerance is how far the local clock may differ from the update server's before
+	// a warning is logged, defaults to 5 minutes
+	ClockSkewTolerance time.Duration
+	// UseServerTime decides maintenance and download windows by the update server's clock,
+	// taken from the Date header of its HTTPS responses, when the local clock is off by more
+	// than ClockSkewTolerance
+	UseServerTime bool
+	// MaxUpdatesPerWindow, when set, caps how many updates are installed within
+	// UpdateBudgetWindow, so that a burst of releases does not restart the application
+	// over and over. Further updates are staged and wait for the budget to allow them.
+	MaxUpdatesPerWindow int
+	// UpdateBudgetWindow is the sliding window MaxUpdatesPerWindow applies to, defaults to 24 hours
+	UpdateBudgetWindow time.Duration
+	// RestartCooldown, when set, is the minimum time between restarts of the application.
+	// An update ready sooner after the last start or install is staged until it has passed.
+	RestartCooldown time.Duration
+	// DownloadWindow, when set, defers downloading an update until the window opens, so that
+	// it is fetched off-peak and staged for the MaintenanceWindow. A newer release appearing
+	// before the staged one was applied supersedes it and is downloaded in the next window.
+	DownloadWindow *Window
+	// ApplyGracePeriod, when set, waits this long after an update is downloaded and verified
+	// before applying it, so that an application is not restarted in the middle of a task
+	ApplyGracePeriod time.Duration
+	// MaxApplyDeferral bounds how far ApplyControl.Defer can postpone an update after it became
+	// ready, defaults to ApplyGracePeriod
+	MaxApplyDeferral time.Duration
+	// ApplyControl, when set, lets the application apply a waiting update early or defer it
+	ApplyControl *ApplyControl
+	// OnUpdateReady, when set, is called with the version when the grace period starts
+	OnUpdateReady func(version string)
+	// StagingDir holds downloads and updates waiting to be applied, defaults to the OS temp dir
+	StagingDir string
+	// ChecksumSources are URLs of checksum files independent of the release, which must all
+	// agree with the release's checksum. "{version}", "{asset}", "{platform}" and "{arch}"
+	// are replaced, e.g. "https://checksums.example.com/{version}/{asset}.sha256".
+	ChecksumSources []string
+	// VersionProbe, when set, runs each downloaded executable with these arguments, such as
+	// "--version", and refuses it unless the output names the version of its release
+	VersionProbe []string
+	// VersionSource, when set, detects the installed version from the executable before each
+	// check instead of trusting CurrentVersion: one of VersionSourceProbe, VersionSourceBuildInfo
+	// or VersionSourceFile
+	VersionSource string
+	// VersionFile is the file holding the installed version for VersionSourceFile
+	VersionFile string
+	// Verifiers run in order on every verified download before it is installed, and any of
+	// them returning an error aborts the update
+	Verifiers []Verifier
+	// CompatibilityChecks add or replace checks for manifest requirements, keyed by requirement name
+	CompatibilityChecks map[string]CompatibilityCheck
+	// AssetPreference is a regular expression preferring some of several assets that match
+	// the platform equally well, such as "-static$" or an exact name
+	AssetPreference string
+	// IncludePrerelease set to true considers pre-releases when looking for the latest release.
+	// Set to false, a release marked as pre-release is never installed in any way, not even
+	// when pinned. Unset, the latest release is whatever GitHub reports as latest.
+	IncludePrerelease *bool
+	// ReleaseScanDepth bounds how many releases are scanned for the latest one, defaults to 100
+	ReleaseScanDepth int
+	// OnMissingAsset decides what happens when a release has no asset for this platform:
+	// MissingAssetError (the default) fails the check, MissingAssetSkip treats it as no
+	// update and looks again on the next check, and MissingAssetWait ignores that version
+	// until a newer release appears. Both log only once per version.
+	OnMissingAsset string
+	// ReadyMarker writes the installed version to "<executable>.ready", or "<TargetDir>.ready",
+	// after each update, so that a supervisor can restart the application. This suits
+	// sidecar deployments where the updater does not run the application itself.
+	ReadyMarker bool
+	// AllowSetuid keeps setuid and setgid bits on files installed from archives and
+	// bundles. By default they are stripped.
+	AllowSetuid bool
+	// SourceArchive installs the release's generated source archive, Tarball or Zipball,
+	// into TargetDir instead of replacing an executable
+	SourceArchive string
+	// TargetDir is the directory replaced by the extracted source archive
+	TargetDir string
+	// TUF, when set, resolves and verifies updates through a TUF repository instead of GitHub
+	TUF *TUFConfig
+	// DirectorySource, when set, takes updates from "<version>" directories in a local
+	// directory, such as a share a CI job drops builds into, instead of GitHub. Each is
+	// verified like a release holding its files as assets.
+	DirectorySource string
+	// ManifestPublicKey, when set, requires every release to ship a manifest signed with the
+	// matching private key, so that neither the version nor the checksums can be forged
+	ManifestPublicKey ed25519.PublicKey
+	// Cosign, when set, requires every release asset to ship a Sigstore bundle, named after
+	// it with ".sigstore.json" appended, proving that the configured identity signed it
+	Cosign *CosignConfig
+	// CertificatePins are SHA-256 digests of public keys, see ParseCertificatePin. When set,
+	// every update server must present one of them in its verified chain, which defeats
+	// interception with a certificate issued by another trusted CA. Several pins allow rotation.
+	CertificatePins [][]byte
+	// ChecksumAlgorithm selects sha256, sha512 or blake2b when a release publishes several
+	// checksums, and resolves 128 character digests of unknown origin. Empty picks automatically.
+	ChecksumAlgorithm string
+	// FileMode is applied to the installed executable, defaults to 0755
+	FileMode os.FileMode
+	// SymlinkStrategy decides how an ExecutablePath that is a symlink is updated: SymlinkTarget
+	// (the default) replaces the file it points to, SymlinkRepoint installs a versioned file
+	// next to it and points the link there. Repointing is not supported on Windows.
+	SymlinkStrategy string
+	// AuditLog, when set, is the path of a hash-chained log of every update applied or
+	// failing to apply, kept apart from the general log. See ReadAuditLog.
+	AuditLog string
+	// FailureBackoff is how long a version that failed verification or could not be applied
+	// is not attempted again. The wait doubles with each further failure of the same version,
+	// up to 24 hours, while newer versions are installed as usual. Network errors and
+	// interrupted downloads are always retried on the next check. Zero disables it.
+	FailureBackoff time.Duration
+	// SlowPhaseThreshold logs a warning for any update phase taking longer, zero disables it
+	SlowPhaseThreshold time.Duration
+
+	// faults holds the errors tests inject into the update pipeline, see fault
+	faults map[faultPhase]error
+	// apiURL replaces the GitHub API endpoint for tests serving releases themselves
+	apiURL string
+	// rootCAs replaces the system roots for tests serving TLS themselves
+	rootCAs *x509.CertPool
+	// cosignVerifier replaces the verifier built from Cosign.TrustedRootPath for tests
+	// signing with a Sigstore instance of their own
+	cosignVerifier *verify.Verifier
+}
+
+// Result describes the outcome of an update check
+type Result struct {
+	// Updated is true when a new version was installed
+	Updated bool `json:"updated"`
+	// Version is the installed version, or the pending one when Deferred
+	Version string `json:"version"`
+	// Deferred is true when an update was downloaded but waits for the maintenance window,
+	// or when it waits for the download window, in which case DownloadAt is set instead.
+	// Neither is set while it waits for the battery to be charged.
+	Deferred   bool      `json:"deferred,omitempty"`
+	ApplyAt    time.Time `json:"apply_at,omitzero"`
+	DownloadAt time.Time `json:"download_at,omitzero"`
+	// Urgency is the urgency the release manifest declares, if any. It is UrgencyCritical
+	// once EnforceAfter has passed.
+	Urgency string `json:"urgency,omitempty"`
+	// EnforceAfter is when a pending update becomes mandatory, so that the application
+	// can warn its users in advance
+	EnforceAfter time.Time `json:"enforce_after,omitzero"`
+	Timings      Timings   `json:"timings"`
+}
+
+// Timings records how long each phase of an update took
+type Timings struct {
+	Check    time.Duration `json:"check"`
+	Download time.Duration `json:"download"`
+	Verify   time.Duration `json:"verify"`
+	Apply    time.Duration `json:"apply"`
+	Total    time.Duration `json:"total"`
 }
 
 // CheckAndUpdate checks for an update and applies it if available
-func CheckAndUpdate(config Config) (bool, error) {
+func CheckAndUpdate(config Config) (*Result, error) {
+	start := time.Now()
+	var timings Timings
+
+	config.CurrentVersion = config.detectVersion()
+
+	result, err := checkAndUpdate(config, &timings)
+
+	timings.Total = time.Since(start)
+	config.warnSlowPhases(timings)
+
+	if result != nil {
+		result.Timings = timings
+	}
+	return result, err
+}
+
+// warnSlowPhases logs every phase that took longer than the configured threshold
+func (c Config) warnSlowPhases(timings Timings) {
+	if c.SlowPhaseThreshold <= 0 {
+		return
+	}
+
+	phases := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"check", timings.Check},
+		{"download", timings.Download},
+		{"verify", timings.Verify},
+		{"apply", timings.Apply},
+		{"total", timings.Total},
+	}
+
+	for _, phase := range phases {
+		if phase.duration > c.SlowPhaseThreshold {
+			c.logf("Warning: update %s phase took %s (threshold %s)", phase.name, phase.duration.Round(time.Millisecond), c.SlowPhaseThreshold)
+		}
+	}
+}
+
+// checkAndUpdate performs the update check, recording phase durations in timings
+func checkAndUpdate(config Config, timings *Timings) (*Result, error) {
+	if config.TUF != nil {
+		return checkAndUpdateTUF(config, timings)
+	}
+	if config.DirectorySource != "" {
+		return checkAndUpdateDirectory(config, timings)
+	}
+
 	parts := strings.Split(config.GithubRepo, "/")
 	if len(parts) != 2 {
-		return false, fmt.Errorf("invalid GitHub repo format, shoulf be 'owner/repo'")
+		return nil, fmt.Errorf("invalid GitHub repo format, shoulf be 'owner/repo'")
 	}
 	owner, repo := parts[0], parts[1]
 
 	ctx := context.Background()
-	var client *github.Client
-	if config.GithubToken != "" {
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: config.GithubToken},
-		)
-		tc := oauth2.NewClient(ctx, ts)
-		client = github.NewClient(tc)
-	} else {
-		client = github.NewClient(nil)
+	client := newGithubClient(config)
+
+	currentVersion := config.CurrentVersion
+
+	if config.PinnedVersion != "" {
+		return updateToPinnedVersion(ctx, client, owner, repo, config, timings)
 	}
 
-	release, _, err := client.Repositories.GetLatestRelease(ctx, owner, repo)
+	checkStart := time.Now()
+	release, err := getLatestRelease(ctx, client, owner, repo, config)
+	timings.Check = time.Since(checkStart)
 	if err != nil {
-		return false, fmt.Errorf("failed to get latest release: %w", err)
+		return nil, fmt.Errorf("failed to get latest release: %w", err)
+	}
+	if release == nil {
+		return &Result{Version: currentVersion}, nil
 	}
 
-	latestVersion := *release.TagName
+	latestVersion, err := releaseVersion(config, release)
+	if err != nil {
+		return nil, err
+	}
 
-	if strings.HasPrefix(latestVersion, "v") {
-		latestVersion = strings.TrimPrefix(latestVersion, "v")
+	switch cmp := compareVersions(latestVersion, currentVersion); {
+	case cmp == 0 && (config.ForceReinstall || config.RepairCorrupted):
+		return applyRelease(ctx, client, config, release, latestVersion, timings)
+	case cmp == 0:
+		return &Result{Version: currentVersion}, nil
+	case cmp < 0 && !config.AllowDowngrade:
+		return &Result{Version: currentVersion}, nil
 	}
 
-	currentVersion := config.CurrentVersion
+	if entry := skipEntry(latestVersion, config.SkipVersions); entry != "" {
+		config.logf("Skipping version %s (matches skip entry %q)", latestVersion, entry)
+		return &Result{Version: currentVersion}, nil
+	}
+
+	if state, err := LoadState(config.ExecutablePath); err == nil {
+		if state.isReverted(latestVersion) {
+			config.logf("Skipping version %s, it was reverted after crashing", latestVersion)
+			return &Result{Version: currentVersion}, nil
+		}
+
+		// Waiting means not even looking at the release again until a newer one appears
+		if config.OnMissingAsset == MissingAssetWait && state.MissingAsset == latestVersion {
+			return &Result{Version: currentVersion}, nil
+		}
+	}
+
+	config.logf("Update available: %s", latestVersion)
 
-	if latestVersion <= currentVersion {
-		return false, nil
+	result, err := applyRelease(ctx, client, config, release, latestVersion, timings)
+	var required *minVersionError
+	if errors.As(err, &required) {
+		return updateThroughVersion(ctx, client, owner, repo, config, required, timings)
 	}
+	return result, err
+}
+
+// maxUpgradeSteps bounds how many min_version requirements are followed back from a release
+const maxUpgradeSteps = 10
+
+// updateThroughVersion installs the release a newer one requires as its min_version instead
+// of jumping straight to the newer release. Intermediate releases may require older ones in
+// turn, so the path is followed back to the first release installable over the current version.
+// The remaining steps are taken by later checks, each after running the previous step.
+func updateThroughVersion(ctx context.Context, client *github.Client, owner, repo string, config Config, required *minVersionError, timings *Timings) (*Result, error) {
+	for step := 0; step < maxUpgradeSteps; step++ {
+		version := required.minVersion
+		config.logf("Version %s requires version %s first, installing it", required.version, version)
+
+		if state, err := LoadState(config.ExecutablePath); err == nil && state.isReverted(version) {
+			return nil, fmt.Errorf("required version %s was reverted after crashing", version)
+		}
+
+		release, err := getReleaseByVersion(ctx, client, owner, repo, version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get required version %s: %w", version, err)
+		}
+
+		result, err := applyRelease(ctx, client, config, release, version, timings)
+		if !errors.As(err, &required) {
+			return result, err
+		}
+	}
+
+	return nil, fmt.Errorf("no upgrade path from %s found within %d releases", config.CurrentVersion, maxUpgradeSteps)
+}
+
+// updateToPinnedVersion installs exactly the pinned version and holds there until the pin changes
+func updateToPinnedVersion(ctx context.Context, client *github.Client, owner, repo string, config Config, timings *Timings) (*Result, error) {
+	pinned := strings.TrimPrefix(config.PinnedVersion, "v")
 
-	log.Printf("Update available: %s", latestVersion)
+	cmp := compareVersions(pinned, config.CurrentVersion)
+	if cmp == 0 && !config.ForceReinstall && !config.RepairCorrupted {
+		return &Result{Version: config.CurrentVersion}, nil
+	}
+	if cmp < 0 && !config.AllowDowngrade {
+		return nil, fmt.Errorf("pinned version %s is older than current version %s and downgrades are not allowed", pinned, config.CurrentVersion)
+	}
+
+	if state, err := LoadState(config.ExecutablePath); err == nil && state.isReverted(pinned) {
+		return nil, fmt.Errorf("pinned version %s was reverted after crashing", pinned)
+	}
+
+	checkStart := time.Now()
+	release, err := getReleaseByVersion(ctx, client, owner, repo, pinned)
+	timings.Check = time.Since(checkStart)
+	if err != nil {
+		return nil, err
+	}
+
+	config.logf("Updating to pinned version: %s", pinned)
+
+	return applyRelease(ctx, client, config, release, pinned, timings)
+}
+
+// applyRelease installs release unless version recently failed to install
+func applyRelease(ctx context.Context, client *github.Client, config Config, release *github.RepositoryRelease, version string, timings *Timings) (*Result, error) {
+	if retryAt := config.failureRetryAt(version); !retryAt.IsZero() {
+		config.logf("Version %s failed to install, not retrying it before %s", version, retryAt.Format(time.RFC3339))
+		return &Result{Version: config.CurrentVersion}, nil
+	}
+
+	result, err := installRelease(ctx, client, config, release, version, timings)
+	if countsAsFailure(err) {
+		config.recordFailure(version)
+	}
+	return result, err
+}
+
+// installRelease downloads the asset for the running platform from release and installs it
+func installRelease(ctx context.Context, client *github.Client, config Config, release *github.RepositoryRelease, version string, timings *Timings) (*Result, error) {
+	if config.excludesPrerelease(release) {
+		return nil, fmt.Errorf("release %s is a pre-release and pre-releases are excluded", release.GetTagName())
+	}
+
+	reinstall := compareVersions(version, config.CurrentVersion) == 0
+
+	if config.SourceArchive != "" {
+		// Extracted trees carry no checksum to detect corruption with
+		if reinstall && !config.ForceReinstall {
+			return &Result{Version: config.CurrentVersion}, nil
+		}
+		if result, err := deferDownload(config, version, &selectedAsset{}); result != nil || err != nil {
+			return result, err
+		}
+		return applySourceArchive(config, release, version, timings)
+	}
+
+	selected, err := resolveWithFallbacks(ctx, client, config, release, version)
+	if errors.Is(err, ErrNoAsset) {
+		return missingAsset(config, version, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkChecksumSources(config, version, selected); err != nil {
+		return nil, err
+	}
+
+	config.ExecutablePath = normalizeExecutablePath(config.ExecutablePath)
+
+	if reinstall {
+		if !config.ForceReinstall && !isCorrupted(config, selected) {
+			return &Result{Version: config.CurrentVersion}, nil
+		}
+		config.logf("Reinstalling version %s", version)
+	}
+
+	tempPath := stagedUpdate(config, version, selected, timings)
+	if tempPath == "" {
+		if result, err := deferDownload(config, version, selected); result != nil || err != nil {
+			return result, err
+		}
+
+		var digest string
+		downloadStart := time.Now()
+		tempPath, digest, err = downloadUpdate(config, selected.asset.GetBrowserDownloadURL(), selected.algorithm, isGzipAsset(selected.name))
+		timings.Download = time.Since(downloadStart)
+		if err != nil {
+			return nil, err
+		}
+
+		verifyStart := time.Now()
+		err = verifyDownload(config, tempPath, selected, digest)
+		timings.Verify = time.Since(verifyStart)
+		if err != nil {
+			os.Remove(tempPath)
+			err = failed(fmt.Errorf("failed to verify download: %w", err))
+			config.audit(version, selected, "", nil, err)
+			return nil, err
+		}
+	}
+
+	return installUpdate(config, version, selected, tempPath, timings)
+}
+
+// installUpdate installs the verified download at tempPath and records the outcome in the audit log
+func installUpdate(config Config, version string, selected *selectedAsset, tempPath string, timings *Timings) (*Result, error) {
+	// Releases without a checksum are recorded with the digest of what was installed
+	var digest string
+	if config.AuditLog != "" && selected.checksum == "" {
+		digest, _ = hashFile(tempPath, SHA256)
+	}
+
+	// Staged downloads are checked again, the bundle covers what is about to be installed
+	var result *Result
+	err := verifyCosign(config, tempPath, selected)
+	if err != nil {
+		os.Remove(tempPath)
+		err = failed(fmt.Errorf("failed to verify download: %w", err))
+	} else {
+		result, err = installVerified(config, version, selected, tempPath, timings)
+	}
+	config.audit(version, selected, digest, result, err)
+	return result, err
+}
+
+// installVerified replaces the executable with the verified download at tempPath, or stages
+// it while the update is held back
+func installVerified(config Config, version string, selected *selectedAsset, tempPath string, timings *Timings) (*Result, error) {
+	if err := checkUniversal(tempPath, selected); err != nil {
+		os.Remove(tempPath)
+		return nil, failed(err)
+	}
+	if err := probeVersion(config, tempPath, version); err != nil {
+		os.Remove(tempPath)
+		return nil, failed(err)
+	}
+	if err := runVerifiers(config, tempPath, version, selected); err != nil {
+		os.Remove(tempPath)
+		return nil, failed(err)
+	}
 
-	platform := runtime.GOOS
-	arch := runtime.GOARCH
+	if selected.enforced(config) {
+		config.logf("Update %s is mandatory since %s", version, selected.enforceAfter.Format(time.RFC3339))
+	}
 
-	assetName := fmt.Sprintf("%s-%s", platform, arch)
-	var downloadURL string
-	for _, asset := range release.Assets {
-		if asset.BrowserDownloadURL != nil && strings.Contains(*asset.Name, assetName) {
-			downloadURL = *asset.BrowserDownloadURL
-			break
+	applyAt, err := config.applyAt(version, selected)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	// A restart interrupted by a drained battery could leave the device unusable
+	if !applyAt.IsZero() || config.lowBattery(version) {
+		stagedPath, err := stageUpdate(config, tempPath, version)
+		if err != nil {
+			return nil, err
 		}
+		config.logf("Update %s staged at %s", version, stagedPath)
+		return &Result{Version: version, Deferred: true, ApplyAt: applyAt, Urgency: selected.urgency, EnforceAfter: selected.enforceAfter}, nil
 	}
 
-	if downloadURL == "" {
-		return false, fmt.Errorf("no suitable asset found for %s/%s", platform, arch)
+	return installReady(config, version, selected, timings, installSteps{
+		apply: func() error { return applyUpdate(config, tempPath, version) },
+		keep: func() {
+			// Keep a verified download staged so the next attempt can skip downloading it again
+			if !selected.verifiable() {
+				os.Remove(tempPath)
+			} else if _, err := stageUpdate(config, tempPath, version); err != nil {
+				config.logf("Failed to keep update staged: %v", err)
+			}
+		},
+		rollback: func() error { return Rollback(config.ExecutablePath, 1) },
+	})
+}
+
+// fileMode returns the permissions for the installed executable
+func (c Config) fileMode() os.FileMode {
+	if c.FileMode != 0 {
+		return c.FileMode
 	}
+	return 0755
+}
 
-	return downloadAndApplyUpdate(config.GithubToken, config.ExecutablePath, downloadURL)
+// logf logs a message through the configured logger, prefixed with the target name, if any
+func (c Config) logf(format string, args ...interface{}) {
+	if c.Name != "" {
+		format = "[" + c.Name + "] " + format
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(format, args...)
 }
 
-// downloadAndApplyUpdate downloads and applies the update
-func downloadAndApplyUpdate(token, executablePath, downloadURL string) (bool, error) {
+// fetchAsset requests a release asset, returning the response only when it succeeded.
+// There is no limit on the whole transfer, which a throttled download of a large asset
+// would exceed, only on waiting for the response and for data, see idleTimeoutTransport.
+func fetchAsset(config Config, downloadURL string) (*http.Response, error) {
 	client := &http.Client{
-		Timeout: 60 * time.Second,
+		Transport:     &idleTimeoutTransport{base: config.transport()},
+		CheckRedirect: checkRedirect,
+	}
+	if strings.HasPrefix(downloadURL, directoryScheme+":") {
+		client.Transport = config.directoryTransport()
 	}
 
-	req, err := http.NewRequest("GET", downloadURL, nil)
+	req, err := http.NewRequestWithContext(config.baseContext(), "GET", downloadURL, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if token != "" {
-		req.Header.Set("Authorization", "token "+token)
+	if config.GithubToken != "" {
+		req.Header.Set("Authorization", "token "+config.GithubToken)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, fmt.Errorf("failed to download update: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("download failed with status code %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed with status code %d", resp.StatusCode)
 	}
 
-	tmpDir := os.TempDir()
-	tempFile, err := os.CreateTemp(tmpDir, "update_*.bin")
+	return resp, nil
+}
+
+// downloadUpdate downloads the update to a temporary file and returns its path together
+// with its digest, computed while streaming when an algorithm is given. A gzipped download
+// is decompressed on the fly, and the digest covers the decompressed executable.
+func downloadUpdate(config Config, downloadURL, algorithm string, gzipped bool) (string, string, error) {
+	var h hash.Hash
+	if algorithm != "" {
+		var err error
+		if h, err = newHash(algorithm); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := config.fault(faultDownload); err != nil {
+		return "", "", fmt.Errorf("failed to download update: %w", err)
+	}
+	resp, err := fetchAsset(config, downloadURL)
 	if err != nil {
-		return false, fmt.Errorf("failed to create temp file: %w", err)
+		return "", "", fmt.Errorf("failed to download update: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A gzipped asset only grows once decompressed, so its Content-Length is a lower bound
+	if err := config.checkFreeSpace(resp.ContentLength); err != nil {
+		return "", "", fmt.Errorf("failed to download update: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(config.stagingDir(), "update_*.bin")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp file: %w", noSpace(err))
 	}
 	tempPath := tempFile.Name()
-	defer os.Remove(tempPath)
 
-	_, err = io.Copy(tempFile, resp.Body)
-	tempFile.Close()
+	var body io.Reader = resp.Body
+	dog := startWatchdog(config, resp.Body)
+	if dog != nil {
+		defer dog.stop()
+		body = dog
+	}
+
+	// The bytes received are counted before decompression, as announced by Content-Length
+	received := &progressReader{r: body, report: func(int64, int64) {}}
+	body = received
+
+	if config.DownloadRateLimit > 0 {
+		body = newRateLimitedReader(resp.Request.Context(), body, config.DownloadRateLimit)
+	}
+
+	if config.Progress != nil {
+		body = &progressReader{r: body, total: resp.ContentLength, report: config.Progress}
+	}
+
+	if gzipped {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+			return "", "", fmt.Errorf("failed to decompress update: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var hashes []hash.Hash
+	if h != nil {
+		hashes = append(hashes, h)
+	}
+
+	// A server may advertise the checksum of the decompressed content as well
+	contentSHA256 := strings.ToLower(resp.Header.Get(contentSHA256Header))
+	contentHash := sha256.New()
+	if contentSHA256 != "" {
+		hashes = append(hashes, contentHash)
+	}
+
+	var out io.Writer = tempFile
+	if err := config.fault(faultWrite); err != nil {
+		out = &failingWriter{w: tempFile, err: err}
+	}
+
+	writers, wait := config.hashWriters(resp.ContentLength, hashes...)
+	_, err = io.Copy(io.MultiWriter(append([]io.Writer{out}, writers...)...), body)
+	wait()
+	// Filesystems that allocate lazily may only report a full disk on close
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if dog != nil && dog.stalled.Load() {
+		os.Remove(tempPath)
+		return "", "", fmt.Errorf("failed to download update: %w", ErrStalled)
+	}
+	if resp.ContentLength >= 0 && received.done != resp.ContentLength && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		os.Remove(tempPath)
+		return "", "", fmt.Errorf("failed to download update: %w: Content-Length is %d bytes, received %d", ErrSizeMismatch, resp.ContentLength, received.done)
+	}
 	if err != nil {
-		return false, fmt.Errorf("failed to write downloaded file: %w", err)
+		// The partial download is removed, so a full disk is not left fuller
+		os.Remove(tempPath)
+		return "", "", fmt.Errorf("failed to write downloaded file: %w", noSpace(err))
 	}
 
-	if err := os.Chmod(tempPath, 0755); err != nil {
-		return false, fmt.Errorf("failed to set permissions: %w", err)
+	if contentSHA256 != "" {
+		if actual := hex.EncodeToString(contentHash.Sum(nil)); actual != contentSHA256 {
+			os.Remove(tempPath)
+			return "", "", fmt.Errorf("%s mismatch: expected %s, got %s", contentSHA256Header, contentSHA256, actual)
+		}
 	}
 
-	backupPath := executablePath + ".bak"
-	if err := copyFile(executablePath, backupPath); err != nil {
-		return false, fmt.Errorf("failed to create backup: %w", err)
+	var digest string
+	if h != nil {
+		digest = hex.EncodeToString(h.Sum(nil))
 	}
+	return tempPath, digest, nil
+}
 
+// applyUpdate backs up the current executable and replaces it with the downloaded file.
+// On failure the downloaded file is left in place for the caller to keep or remove.
+func applyUpdate(config Config, tempPath, version string) error {
+	executablePath := config.ExecutablePath
+
+	switch config.SymlinkStrategy {
+	case "", SymlinkTarget, SymlinkRepoint:
+	default:
+		return fmt.Errorf("unknown symlink strategy %q", config.SymlinkStrategy)
+	}
+
+	if err := os.Chmod(tempPath, config.fileMode()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	backup := ""
+	if config.DisableBackup {
+		config.logf("Warning: backups are disabled, the current executable cannot be restored if the update fails")
+	} else if err := pushBackup(executablePath, config.CurrentVersion); err != nil {
+		return fmt.Errorf("failed to create backup: %w", noSpace(err))
+	} else {
+		backup = backupPath(executablePath, 1)
+	}
+
+	var err error
 	if runtime.GOOS == "windows" {
-		// On Windows, we need to use a batch file for replacement
-		return true, replaceExecutableWindows(tempPath, executablePath)
+		// On Windows, we need to use a batch file for replacement, which also removes the downloaded
+		// file. It restores the backup if the copy fails, and the backup is kept either way.
+		err = replaceExecutableWindows(tempPath, resolveLink(executablePath), backup, config.RestartArgs)
+	} else if config.SymlinkStrategy == SymlinkRepoint && isSymlink(executablePath) {
+		err = repointSymlink(executablePath, tempPath, version)
+	} else {
+		if err = config.fault(faultRename); err == nil {
+			err = os.Rename(tempPath, resolveLink(executablePath))
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to replace executable: %w", err)
+		}
+	}
+
+	if backup == "" {
+		return err
+	}
+	if err != nil {
+		// The executable was left untouched, so the backup of it is dropped again
+		popBackup(executablePath)
+		return err
+	}
+
+	pruneBackups(executablePath, config.BackupCount)
+	return nil
+}
+
+// progressReader reports the number of bytes read so far
+type progressReader struct {
+	r      io.Reader
+	total  int64
+	done   int64
+	report func(done, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.report(p.done, p.total)
 	}
+	return n, err
+}
+
+// rateLimitedReader throttles reads from an underlying reader
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
 
-	// On not windows replace directly
-	if err := os.Rename(tempPath, executablePath); err != nil {
-		// If failed restore backup
-		os.Rename(backupPath, executablePath)
-		return false, fmt.Errorf("failed to replace executable: %w", err)
+// newRateLimitedReader wraps r so that it yields at most bytesPerSec bytes per second
+func newRateLimitedReader(ctx context.Context, r io.Reader, bytesPerSec int64) io.Reader {
+	return &rateLimitedReader{
+		ctx:     ctx,
+		r:       r,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec)),
+	}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Never read more than the limiter can grant at once
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
 	}
 
-	return true, nil
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
 }
 
+// checkRedirect drops the Authorization header when a redirect leaves GitHub.
+// Release assets are served from a signed storage URL on another host, which
+// rejects the extra header and must never see the token.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+
+	if !isGithubHost(req.URL.Hostname()) {
+		req.Header.Del("Authorization")
+	}
+
+	return nil
+}
+
+// isGithubHost reports whether host is github.com or api.github.com
+func isGithubHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "github.com" || host == "api.github.com"
+}
+
+// replaceFailedExt is appended to the executable path to form the marker the Windows
+// replacement script leaves behind when it could not install the update
+const replaceFailedExt = ".update-failed"
+
+// replaceAttempts is how often the Windows replacement script tries to copy the update
+const replaceAttempts = 5
+
 // replaceExecutableWindows creates a batch file for Windows to replace the executable after process exit
-func replaceExecutableWindows(newFile, targetFile string) error {
-	batchContent := fmt.Sprintf(`@echo off
-:retry
+// and start it again with args. The copy is retried and compared against the update before the
+// update is deleted. If it keeps failing, the backup, if any, is restored and the failure is
+// recorded for ReplaceFailure to report on the next start.
+func replaceExecutableWindows(newFile, targetFile, backupFile string, args []string) error {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = `"` + arg + `"`
+	}
+
+	batchContent := strings.NewReplacer(
+		"{new}", newFile,
+		"{target}", targetFile,
+		"{backup}", backupFile,
+		"{args}", strings.Join(quoted, " "),
+		"{attempts}", strconv.Itoa(replaceAttempts),
+		"{marker}", targetFile+replaceFailedExt,
+	).Replace(`@echo off
+set attempts=0
+:wait
+ping -n 2 127.0.0.1 > nul
+del "{target}"
+if exist "{target}" goto wait
+:copy
+set /a attempts+=1
+copy /y "{new}" "{target}" > nul
+if errorlevel 1 goto failed
+fc /b "{new}" "{target}" > nul
+if errorlevel 1 goto failed
+start "" "{target}" {args}
+del "{new}"
+del "%~f0"
+exit /b 0
+:failed
 ping -n 2 127.0.0.1 > nul
-del "%s"
-if exist "%s" goto retry
-copy /y "%s" "%s"
-start "" "%s" %s
-del "%s"
-del "%%~f0"
-`, targetFile, targetFile, newFile, targetFile, targetFile, strings.Join(os.Args[1:], " "), newFile)
+if %attempts% lss {attempts} goto copy
+echo Copying "{new}" to "{target}" failed after %attempts% attempts> "{marker}"
+if not "{backup}"=="" if exist "{backup}" copy /y "{backup}" "{target}" > nul
+start "" "{target}" {args}
+del "%~f0"
+`)
 
 	batchPath := filepath.Join(os.TempDir(), "update.bat")
 	if err := os.WriteFile(batchPath, []byte(batchContent), 0700); err != nil {
@@ -169,22 +923,38 @@ del "%%~f0"
 	return cmd.Start()
 }
 
-// RestartApplication restarts the application
-func RestartApplication(executablePath string, args []string) {
+// ReplaceFailure returns and clears the failure the Windows replacement script recorded
+// for the executable, or an empty string if the last replacement succeeded
+func ReplaceFailure(executablePath string) string {
+	marker := normalizeExecutablePath(executablePath) + replaceFailedExt
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		return ""
+	}
+	os.Remove(marker)
+	return strings.TrimSpace(string(data))
+}
+
+// RestartApplication starts executablePath with args and exits the current process.
+// It only returns when the new process could not be started.
+func RestartApplication(executablePath string, args []string) error {
+	// The new process must not mistake this exit for a crash
+	RecordExit(executablePath)
+
 	cmd := exec.Command(executablePath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
 	if err := cmd.Start(); err != nil {
-		log.Printf("Failed to restart application: %v", err)
-		return
+		return fmt.Errorf("failed to restart application: %w", err)
 	}
 
 	os.Exit(0)
+	return nil
 }
 
-// copyFile copies a file from src to dst
+// copyFile copies a file from src to dst, keeping the permissions of src
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -192,12 +962,21 @@ func copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if _, err := io.Copy(destFile, sourceFile); err != nil {
+		return err
+	}
+
+	// OpenFile only applies the mode to new files
+	return destFile.Chmod(info.Mode().Perm())
 }
@@ -0,0 +1,111 @@
+package updater
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchAssetDropsAuthorizationOnRedirect(t *testing.T) {
+	var gotAuth string
+	assets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		io.WriteString(w, "asset")
+	}))
+	defer assets.Close()
+
+	var sentAuth string
+	releases := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sentAuth = r.Header.Get("Authorization")
+		http.Redirect(w, r, assets.URL+"/asset", http.StatusFound)
+	}))
+	defer releases.Close()
+
+	resp, err := fetchAsset(Config{GithubToken: "secret"}, releases.URL+"/download")
+	if err != nil {
+		t.Fatalf("fetchAsset: %v", err)
+	}
+	resp.Body.Close()
+
+	if sentAuth != "token secret" {
+		t.Fatalf("first server got Authorization %q, want the token", sentAuth)
+	}
+	if gotAuth != "" {
+		t.Errorf("redirected server got Authorization %q, want none", gotAuth)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		url      string
+		keepAuth bool
+	}{
+		{"https://api.github.com/repos/o/r/releases/assets/1", true},
+		{"https://github.com/o/r/releases/download/v1/app", true},
+		{"https://objects.githubusercontent.com/app", false},
+		{"https://evil.example.com/app", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "token secret")
+
+			if err := checkRedirect(req, []*http.Request{{}}); err != nil {
+				t.Fatalf("checkRedirect: %v", err)
+			}
+			if kept := req.Header.Get("Authorization") != ""; kept != tt.keepAuth {
+				t.Errorf("Authorization kept = %v, want %v", kept, tt.keepAuth)
+			}
+		})
+	}
+}
+
+func TestCheckAndUpdate(t *testing.T) {
+	f := newPipelineFixture(t)
+
+	result, err := CheckAndUpdate(f.config)
+	if err != nil {
+		t.Fatalf("CheckAndUpdate: %v", err)
+	}
+	if !result.Updated || result.Version != "1.1.0" {
+		t.Errorf("CheckAndUpdate() = %+v, want version 1.1.0 installed", result)
+	}
+
+	assertFile(t, f.exe, "binary 1.1.0")
+	assertFile(t, backupPath(f.exe, 1), "binary 1.0.0")
+	assertNoDownloads(t, f.config)
+	if f.downloads[f.asset] != 1 || f.downloads[f.asset+".sha256"] != 1 {
+		t.Errorf("downloads = %v, want the asset and its checksum once each", f.downloads)
+	}
+
+	// The installed version is up to date on the next check
+	config := f.config
+	config.CurrentVersion = "1.1.0"
+	if result, err := CheckAndUpdate(config); err != nil || result.Updated {
+		t.Errorf("CheckAndUpdate() at 1.1.0 = %+v, %v, want no update", result, err)
+	}
+	if f.downloads[f.asset] != 1 {
+		t.Errorf("asset downloaded %d times, want once", f.downloads[f.asset])
+	}
+}
+
+func TestCheckAndUpdateRejectsChecksumMismatch(t *testing.T) {
+	f := newPipelineFixture(t)
+	f.files[f.asset] = "binary 6.6.6"
+
+	_, err := CheckAndUpdate(f.config)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("CheckAndUpdate() error = %v, want a checksum mismatch", err)
+	}
+
+	assertFile(t, f.exe, "binary 1.0.0")
+	assertMissing(t, backupPath(f.exe, 1))
+	assertMissing(t, f.config.stagedPath("1.1.0"))
+	assertNoDownloads(t, f.config)
+}
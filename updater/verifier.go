@@ -0,0 +1,46 @@
+// updater/verifier.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrRejected is returned when a verifier refused an update
+var ErrRejected = errors.New("update rejected by verifier")
+
+// ReleaseInfo describes the update a verifier is asked about
+type ReleaseInfo struct {
+	Version   string
+	AssetName string
+	// Algorithm and Checksum are what the download was verified against, empty when the
+	// release publishes no checksum
+	Algorithm string
+	Checksum  string
+	Urgency   string
+}
+
+// Verifier is an additional gate, such as a malware scan or a policy check, run on the
+// verified download at path before it is installed. Returning an error aborts the update.
+// ctx is Config.Context, a verifier should stop once it is cancelled.
+type Verifier func(ctx context.Context, path string, release ReleaseInfo) error
+
+// runVerifiers runs the configured verifiers in order, stopping at the first rejection
+func runVerifiers(config Config, path, version string, selected *selectedAsset) error {
+	release := ReleaseInfo{
+		Version:   version,
+		AssetName: selected.name,
+		Algorithm: selected.algorithm,
+		Checksum:  selected.checksum,
+		Urgency:   selected.urgency,
+	}
+
+	for i, verify := range config.Verifiers {
+		if err := verify(config.baseContext(), path, release); err != nil {
+			config.logf("Verifier %d of %d rejected version %s: %v", i+1, len(config.Verifiers), version, err)
+			return fmt.Errorf("%w %d: %v", ErrRejected, i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package updater
+
+import (
+	"context"
+	"testing"
+)
+
+type contextKey struct{}
+
+func TestRunVerifiersPassesConfigContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKey{}, "config")
+	var got context.Context
+	config := Config{
+		Context: ctx,
+		Verifiers: []Verifier{func(ctx context.Context, path string, release ReleaseInfo) error {
+			got = ctx
+			return nil
+		}},
+	}
+
+	if err := runVerifiers(config, "update.bin", "1.1.0", &selectedAsset{}); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Value(contextKey{}) != "config" {
+		t.Error("verifier was not passed Config.Context")
+	}
+}
@@ -0,0 +1,234 @@
+// updater/verify.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+)
+
+// VerificationReport is the outcome of checking a release end to end without installing it
+type VerificationReport struct {
+	// Version is the release that was verified, empty if none was found
+	Version string
+	// Asset is the name of the asset selected for the running platform
+	Asset string
+	Steps []VerificationStep
+}
+
+// VerificationStep is one check of a VerificationReport
+type VerificationStep struct {
+	Name   string
+	Passed bool
+	// Skipped steps are not configured for this updater and count as passed
+	Skipped bool
+	// Detail describes what was checked, or why the step failed
+	Detail   string
+	Duration time.Duration
+}
+
+// Passed reports whether every step of the verification passed
+func (r *VerificationReport) Passed() bool {
+	for _, step := range r.Steps {
+		if !step.Passed {
+			return false
+		}
+	}
+	return len(r.Steps) > 0
+}
+
+// run records the outcome of a step and reports whether it passed
+func (r *VerificationReport) run(name string, step func() (string, error)) bool {
+	start := time.Now()
+	detail, err := step()
+	if err != nil {
+		detail = err.Error()
+	}
+	r.Steps = append(r.Steps, VerificationStep{Name: name, Passed: err == nil, Detail: detail, Duration: time.Since(start)})
+	return err == nil
+}
+
+// skip records a step that does not apply
+func (r *VerificationReport) skip(name, reason string) {
+	r.Steps = append(r.Steps, VerificationStep{Name: name, Passed: true, Skipped: true, Detail: reason})
+}
+
+// VerifyRelease runs the whole update flow for the latest release, or the pinned version,
+// up to installing it: selecting the asset, verifying the manifest signature, checksum
+// sources, download, checksum, the slices of a universal binary, version probe and
+// Verifiers. The download goes to a sandbox directory that is removed afterwards, and
+// the installed executable, backups and state are never touched. Skip lists, windows
+// and the battery are ignored, and the release is verified even when it is not newer
+// than CurrentVersion.
+//
+// A failing step ends the verification and is reported in the returned report. An error
+// is only returned when the verification could not be run at all.
+func VerifyRelease(config Config) (*VerificationReport, error) {
+	if config.TUF != nil {
+		return nil, errors.New("verifying releases of a TUF repository is not supported")
+	}
+	if config.DirectorySource != "" {
+		return nil, errors.New("verifying releases of a directory source is not supported")
+	}
+	if config.SourceArchive != "" {
+		return nil, errors.New("verifying source archive releases is not supported")
+	}
+
+	owner, repo, ok := strings.Cut(config.GithubRepo, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid GitHub repo format, should be 'owner/repo'")
+	}
+
+	sandbox, err := os.MkdirTemp("", "ota-verify-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+	defer os.RemoveAll(sandbox)
+	config.StagingDir = sandbox
+
+	ctx := context.Background()
+	client := newGithubClient(config)
+	report := &VerificationReport{}
+
+	var release *github.RepositoryRelease
+	if !report.run("release", func() (string, error) {
+		var err error
+		if config.PinnedVersion != "" {
+			release, err = getReleaseByVersion(ctx, client, owner, repo, strings.TrimPrefix(config.PinnedVersion, "v"))
+		} else {
+			release, err = getLatestRelease(ctx, client, owner, repo, config)
+		}
+		if err != nil {
+			return "", err
+		}
+		if release == nil {
+			return "", fmt.Errorf("%s has no releases", config.GithubRepo)
+		}
+		if config.excludesPrerelease(release) {
+			return "", fmt.Errorf("release %s is a pre-release and pre-releases are excluded", release.GetTagName())
+		}
+		if report.Version, err = releaseVersion(config, release); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("version %s from release %s", report.Version, release.GetTagName()), nil
+	}) {
+		return report, nil
+	}
+
+	var selected *selectedAsset
+	if !report.run("asset", func() (string, error) {
+		var err error
+		if selected, err = resolveWithFallbacks(ctx, client, config, release, report.Version); err != nil {
+			return "", err
+		}
+		report.Asset = selected.name
+
+		detail := fmt.Sprintf("%s for %s/%s", selected.name, runtime.GOOS, runtime.GOARCH)
+		if config.ManifestPublicKey != nil {
+			detail += ", manifest signature valid"
+		}
+		return detail, nil
+	}) {
+		return report, nil
+	}
+
+	if len(config.ChecksumSources) == 0 {
+		report.skip("checksum sources", "no checksum sources configured")
+	} else if !report.run("checksum sources", func() (string, error) {
+		if err := checkChecksumSources(config, report.Version, selected); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d sources agree", len(config.ChecksumSources)), nil
+	}) {
+		return report, nil
+	}
+
+	var tempPath, digest string
+	if !report.run("download", func() (string, error) {
+		var err error
+		tempPath, digest, err = downloadUpdate(config, selected.asset.GetBrowserDownloadURL(), selected.algorithm, isGzipAsset(selected.name))
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(tempPath)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d bytes", info.Size()), nil
+	}) {
+		return report, nil
+	}
+
+	if selected.checksum == "" && selected.size == 0 {
+		report.skip("checksum", "the release publishes no checksum for "+selected.name)
+	} else if !report.run("checksum", func() (string, error) {
+		if err := verifyDownload(config, tempPath, selected, digest); err != nil {
+			return "", err
+		}
+		if selected.checksum == "" {
+			return fmt.Sprintf("size %d bytes, the release publishes no checksum", selected.size), nil
+		}
+		return fmt.Sprintf("%s %s", selected.algorithm, selected.checksum), nil
+	}) {
+		return report, nil
+	}
+
+	if selected.preInstall == nil && selected.postInstall == nil {
+		report.skip("install scripts", "the release declares no install scripts")
+	} else if !report.run("install scripts", func() (string, error) {
+		var names []string
+		for _, script := range []*installScript{selected.preInstall, selected.postInstall} {
+			if script == nil {
+				continue
+			}
+			if _, err := config.fetchScript(script, sandbox); err != nil {
+				return "", err
+			}
+			names = append(names, script.phase+" "+script.name)
+		}
+		return strings.Join(names, ", ") + " verified, not run", nil
+	}) {
+		return report, nil
+	}
+
+	if !selected.universal {
+		report.skip("universal binary", selected.name+" is built for a single architecture")
+	} else if !report.run("universal binary", func() (string, error) {
+		if err := checkUniversal(tempPath, selected); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("contains a %s slice", runtime.GOARCH), nil
+	}) {
+		return report, nil
+	}
+
+	if len(config.VersionProbe) == 0 {
+		report.skip("version probe", "no version probe configured")
+	} else if !report.run("version probe", func() (string, error) {
+		if err := probeVersion(config, tempPath, report.Version); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("executable reports version %s", report.Version), nil
+	}) {
+		return report, nil
+	}
+
+	if len(config.Verifiers) == 0 {
+		report.skip("verifiers", "no verifiers configured")
+	} else {
+		report.run("verifiers", func() (string, error) {
+			if err := runVerifiers(config, tempPath, report.Version, selected); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d verifiers accepted the executable", len(config.Verifiers)), nil
+		})
+	}
+
+	return report, nil
+}
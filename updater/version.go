@@ -0,0 +1,154 @@
+// updater/version.go
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsedVersion holds the comparable parts of a version such as "v1.2.3-rc.1+build.5"
+type parsedVersion struct {
+	core       []int
+	prerelease string
+}
+
+// parseVersion parses a dotted numeric version with an optional pre-release suffix.
+// A leading "v" and build metadata after "+" are ignored.
+func parseVersion(v string) (parsedVersion, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+
+	var parsed parsedVersion
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, parsed.prerelease = s[:i], s[i+1:]
+	}
+
+	if s == "" {
+		return parsedVersion{}, fmt.Errorf("invalid version %q", v)
+	}
+
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsedVersion{}, fmt.Errorf("invalid version %q", v)
+		}
+		parsed.core = append(parsed.core, n)
+	}
+
+	return parsed, nil
+}
+
+// IsPrerelease reports whether version carries a pre-release suffix such as "-rc.1"
+func IsPrerelease(version string) bool {
+	parsed, err := parseVersion(version)
+	return err == nil && parsed.prerelease != ""
+}
+
+// compareVersions returns -1, 0 or 1 depending on whether a is older than, equal to
+// or newer than b. Versions that cannot be parsed are compared as plain strings.
+func compareVersions(a, b string) int {
+	pa, errA := parseVersion(a)
+	pb, errB := parseVersion(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v"))
+	}
+
+	for i := 0; i < len(pa.core) || i < len(pb.core); i++ {
+		var x, y int
+		if i < len(pa.core) {
+			x = pa.core[i]
+		}
+		if i < len(pb.core) {
+			y = pb.core[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A pre-release sorts before the release it precedes
+	switch {
+	case pa.prerelease == pb.prerelease:
+		return 0
+	case pa.prerelease == "":
+		return 1
+	case pb.prerelease == "":
+		return -1
+	}
+	return comparePrerelease(pa.prerelease, pb.prerelease)
+}
+
+// comparePrerelease compares dot separated pre-release identifiers, numerically when both are numbers
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+
+		var c int
+		if errX == nil && errY == nil {
+			c = x - y
+		} else {
+			c = strings.Compare(as[i], bs[i])
+		}
+
+		if c < 0 {
+			return -1
+		}
+		if c > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// matchesConstraint reports whether version satisfies constraint, which is either an exact
+// version or a version prefixed by one of the operators <, <=, >, >=, = or !=
+func matchesConstraint(version, constraint string) bool {
+	constraint = strings.TrimSpace(constraint)
+	for _, op := range []string{"<=", ">=", "!=", "<", ">", "="} {
+		if !strings.HasPrefix(constraint, op) {
+			continue
+		}
+
+		cmp := compareVersions(version, strings.TrimSpace(constraint[len(op):]))
+		switch op {
+		case "<=":
+			return cmp <= 0
+		case ">=":
+			return cmp >= 0
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case ">":
+			return cmp > 0
+		}
+		return cmp == 0
+	}
+
+	return compareVersions(version, constraint) == 0
+}
+
+// skipEntry returns the first entry of skip that version matches, or an empty string
+func skipEntry(version string, skip []string) string {
+	for _, entry := range skip {
+		if matchesConstraint(version, entry) {
+			return entry
+		}
+	}
+	return ""
+}
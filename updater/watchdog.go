@@ -0,0 +1,135 @@
+// updater/watchdog.go
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned when a download stayed below MinDownloadRate for a whole StallPeriod
+var ErrStalled = errors.New("download stalled")
+
+// defaultStallPeriod is how long throughput may stay below the floor by default
+const defaultStallPeriod = 30 * time.Second
+
+// responseTimeout bounds how long the response to a download request may take to arrive
+const responseTimeout = 60 * time.Second
+
+// idleTimeout bounds how long a single read of a download may wait for data
+const idleTimeout = 60 * time.Second
+
+// idleTimeoutTransport aborts requests whose response does not arrive within
+// responseTimeout, or whose body delivers no data within idleTimeout of a read. Unlike a
+// timeout on the whole transfer it never fails a download that is slow but progressing,
+// such as a throttled one; the watchdog catches those that progress too slowly.
+type idleTimeoutTransport struct {
+	base http.RoundTripper
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(responseTimeout, func() {
+		cancel(fmt.Errorf("no response within %s", responseTimeout))
+	})
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		cancel(nil)
+		return nil, err
+	}
+
+	resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, ctx: ctx, cancel: cancel}
+	return resp, nil
+}
+
+// idleTimeoutBody aborts a response body that delivers no data within idleTimeout of a
+// read. Only the time spent waiting in a read counts, not time spent throttled.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(idleTimeout, func() {
+		b.cancel(fmt.Errorf("no data received for %s", idleTimeout))
+	})
+	n, err := b.ReadCloser.Read(p)
+	timer.Stop()
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); cause != nil {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+// Close closes the body and releases its context
+func (b *idleTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// watchdog aborts a download that trickles in too slowly to ever finish, which the idle
+// timeout never catches as long as some data keeps arriving
+type watchdog struct {
+	body     io.ReadCloser
+	received atomic.Int64
+	stalled  atomic.Bool
+	done     chan struct{}
+}
+
+// startWatchdog monitors reads from body, closing it once fewer bytes than MinDownloadRate
+// allows arrive within a StallPeriod. It returns nil when no floor is configured.
+func startWatchdog(config Config, body io.ReadCloser) *watchdog {
+	if config.MinDownloadRate <= 0 {
+		return nil
+	}
+
+	period := config.StallPeriod
+	if period <= 0 {
+		period = defaultStallPeriod
+	}
+	floor := int64(float64(config.MinDownloadRate) * period.Seconds())
+
+	w := &watchdog{body: body, done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				if received := w.received.Swap(0); received < floor {
+					config.logf("Download received %d bytes in %s, below the floor of %d bytes/s, aborting", received, period, config.MinDownloadRate)
+					w.stalled.Store(true)
+					body.Close()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// Read counts the bytes received from the monitored body
+func (w *watchdog) Read(p []byte) (int, error) {
+	n, err := w.body.Read(p)
+	w.received.Add(int64(n))
+	return n, err
+}
+
+// stop ends monitoring
+func (w *watchdog) stop() {
+	close(w.done)
+}
@@ -0,0 +1,72 @@
+// updater/window.go
+package updater
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a daily time range, such as 02:00 to 04:00, in which an action is allowed.
+// A window whose end is before its start spans midnight.
+type Window struct {
+	Start string // "HH:MM"
+	End   string // "HH:MM"
+	// Timezone is an IANA zone name such as "Europe/Berlin", local time when empty
+	Timezone string
+}
+
+// bounds returns the start and end of the window occurrence beginning on the day of t
+func (w *Window) bounds(t time.Time) (time.Time, time.Time, error) {
+	loc := time.Local
+	if w.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid window timezone: %w", err)
+		}
+	}
+
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid window start %q: %w", w.Start, err)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid window end %q: %w", w.End, err)
+	}
+
+	t = t.In(loc)
+	y, m, d := t.Date()
+	from := time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, loc)
+	to := time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, loc)
+	if !to.After(from) {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return from, to, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *Window) Contains(t time.Time) (bool, error) {
+	from, to, err := w.bounds(t)
+	if err != nil {
+		return false, err
+	}
+
+	// The occurrence that started the day before may still be open after midnight
+	prevFrom, prevTo := from.AddDate(0, 0, -1), to.AddDate(0, 0, -1)
+
+	return (!t.Before(from) && t.Before(to)) || (!t.Before(prevFrom) && t.Before(prevTo)), nil
+}
+
+// Next returns the next time the window opens after t
+func (w *Window) Next(t time.Time) (time.Time, error) {
+	from, _, err := w.bounds(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !from.After(t) {
+		from = from.AddDate(0, 0, 1)
+	}
+	return from, nil
+}